@@ -1,25 +1,25 @@
-package main
+package melange
 
 import (
 	"bytes"
 	_ "embed"
-	"flag"
+	"encoding/json"
 	"fmt"
+	htmltemplate "html/template"
 	"io"
 	"io/ioutil"
-	"log"
-	"net/http"
 	"os"
 	"path"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
 	"text/template"
+	"text/template/parse"
 	"time"
 
 	"github.com/yuin/goldmark"
 	meta "github.com/yuin/goldmark-meta"
-	"github.com/yuin/goldmark/extension"
-	"github.com/yuin/goldmark/parser"
 	html "github.com/yuin/goldmark/renderer/html"
 )
 
@@ -38,99 +38,364 @@ type asset struct {
 
 type props map[string]any
 
-type element struct {
-	id    string
-	src   string
-	csr   bool
-	ssr   bool
-	token string
-	props props
-}
-
-type page struct {
-	id       string
-	absPath  string
-	dir      string
-	relPath  string
-	depth    int
-	template *template.Template
-	Contents string
-	Url      string
-	Data     map[string]any
-	Name     string
-	elements []*element
-}
-
-type config struct {
+// Element is a component that was rendered by a page with the render func.
+type Element struct {
+	id        string
+	src       string
+	dir       string
+	framework *framework
+	csr       bool
+	ssr       bool
+	visible   bool
+	idle      bool
+	media     string
+	token     string
+	props     props
+}
+
+// Page is a markdown file in the site's pages directory.
+type Page struct {
+	id        string
+	absPath   string
+	dir       string
+	relPath   string
+	outputDir string
+	depth     int
+	template  *template.Template
+	Contents  string
+	Url       string
+	Data      map[string]any
+	Name      string
+	// Title is the title from the page's front matter, or its first heading,
+	// or its file name.
+	Title string
+	// mu guards elements, which are added while the page renders.
+	mu       sync.Mutex
+	elements []*Element
+	// scripts are the urls of the page's client bundles
+	scripts []string
+	// clientInputs are the absolute paths of the files that went into the
+	// page's client bundles. They're only tracked in development.
+	clientInputs map[string]bool
+	// staticInputs are the absolute paths of the files that went into the
+	// page's server rendered elements.
+	staticInputs []string
+	// cacheKey identifies everything that the page's contents depend on.
+	cacheKey string
+	// cached is set if the page was restored from the build cache, rather
+	// than rendered.
+	cached bool
+	// taxonomy is set for the pages that are generated for a taxonomy, and
+	// term is set if the page lists one of its terms.
+	taxonomy *Taxonomy
+	term     *Term
+	// paginator is set if the page called paginate. The pages after the
+	// first are copies of the page with their own pageNumber, and source
+	// set to the first page.
+	paginator  *Paginator
+	pageNumber int
+	source     *Page
+}
+
+// Site holds the configuration and contents of a site during a build.
+type Site struct {
 	production  bool
 	inputDir    string
 	outputDir   string
 	pagesDir    string
 	assetsDir   string
 	cacheDir    string
-	pages       map[string]*page
+	pages       map[string]*Page
 	assets      []*asset
 	directories []string
 	markdown    goldmark.Markdown
-	template    *template.Template
-	framework   framework
+	template    *htmltemplate.Template
+	framework   *framework
+	funcs       template.FuncMap
+	liveReload  bool
+	incremental *incrementalBuild
+	themePath   string
+	// layouts are the layouts in each directory, keyed by the directory's
+	// absolute path. namedLayouts are the layouts in "_layouts".
+	layouts      map[string]*layout
+	namedLayouts map[string]*layout
+	partials     *partials
+	config       *siteConfig
+	// taxonomies are filled in once every page has been read, and generated
+	// taxonomy pages are rendered with taxonomyTemplate.
+	taxonomies       *taxonomyIndex
+	taxonomyTemplate *htmltemplate.Template
+	taxonomyPath     string
+	// data is loaded from the files in "_data", which are kept in dataFiles.
+	data      map[string]any
+	dataFiles []string
+	runtime   *jsRuntime
+	workers   int
+	host      renderer
+	cache     *buildCache
+}
+
+// absPath resolves a path relative to the site's root directory.
+func (site *Site) absPath(name string) string {
+	return resolvePath(site.inputDir, name)
+}
+
+// InputDir returns the root directory of the site.
+func (site *Site) InputDir() string {
+	return site.inputDir
+}
+
+// OutputDir returns the directory the site is built into.
+func (site *Site) OutputDir() string {
+	return site.outputDir
+}
+
+// Title returns the site's title from its config.
+func (site *Site) Title() string {
+	return site.config.Title
+}
+
+// BaseURL returns the url that the site is published at, from its config.
+func (site *Site) BaseURL() string {
+	return site.config.BaseURL
+}
+
+// Author returns the site's author from its config.
+func (site *Site) Author() string {
+	return site.config.Author
+}
+
+// Data returns the values loaded from the files in the "_data" directory,
+// keyed by their names without extensions.
+func (site *Site) Data() map[string]any {
+	return site.data
+}
+
+// Pages returns the site's pages, sorted by path.
+func (site *Site) Pages() []*Page {
+	pages := make([]*Page, 0, len(site.pages))
+
+	for _, page := range site.pages {
+		pages = append(pages, page)
+	}
+
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].relPath < pages[j].relPath
+	})
+
+	return pages
+}
+
+// Path returns the page's path, relative to the pages directory.
+func (page *Page) Path() string {
+	return page.relPath
+}
+
+// OutputPath returns the absolute path that the page is written to.
+func (page *Page) OutputPath() string {
+	return strings.Replace(path.Join(page.outputDir, page.relPath), ".md", ".html", 1)
+}
+
+// injectHead inserts html at the end of the page's head.
+func (page *Page) injectHead(html string) {
+	page.Contents = strings.Replace(page.Contents, "</head>", html+"</head>", 1)
+}
+
+// Elements returns the elements that the page rendered.
+func (page *Page) Elements() []*Element {
+	page.mu.Lock()
+	defer page.mu.Unlock()
+	return page.elements
+}
+
+// ID returns the element's unique id, which is also the id of the element's
+// container in the rendered page, if it is hydrated.
+func (e *Element) ID() string {
+	return e.id
+}
+
+// Src returns the path to the element's component, as it was passed to render.
+func (e *Element) Src() string {
+	return e.src
+}
+
+// Props returns the props that the element was rendered with.
+func (e *Element) Props() map[string]any {
+	return e.props
 }
 
-func createConfig(inputDir string, production bool) (config, error) {
-	outputDir := path.Join(inputDir, "_site")
-	pagesDir := path.Join(inputDir, "pages")
-	assetsDir := path.Join(outputDir, "_assets")
+// Framework returns the name of the framework that renders the element.
+func (e *Element) Framework() string {
+	return e.framework.name
+}
+
+// Hydrated reports whether the element is rendered at the client.
+func (e *Element) Hydrated() bool {
+	return e.csr
+}
+
+// siteDir returns the root directory of the site, which defaults to the
+// working directory.
+func siteDir(options BuildOptions) (string, error) {
+	if options.Dir != "" {
+		return options.Dir, nil
+	}
+
+	return os.Getwd()
+}
+
+// siteRuntime returns the runtime that renders the site's components, and
+// the number of processes that it runs.
+func siteRuntime(options BuildOptions) (*jsRuntime, int, error) {
+	runtimeName := options.Runtime
+
+	if runtimeName == "" {
+		runtimeName = "node"
+	}
+
+	hostRuntime, err := getRuntime(runtimeName)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	workers := options.Workers
+
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+
+	return hostRuntime, workers, nil
+}
+
+func createSite(options BuildOptions) (Site, error) {
+	inputDir, err := siteDir(options)
+
+	if err != nil {
+		return Site{}, err
+	}
+
+	config, err := loadConfig(inputDir)
+
+	if err != nil {
+		return Site{}, err
+	}
+
+	frameworkName := options.Framework
+
+	if frameworkName == "" {
+		frameworkName = config.Framework
+	}
+
+	if frameworkName == "" {
+		frameworkName = preact.name
+	}
+
+	outputDir := resolvePath(inputDir, config.OutputDir)
+	pagesDir := resolvePath(inputDir, config.PagesDir)
+	assetsDir := path.Join(outputDir, config.AssetsDir)
 	cacheDir := path.Join(inputDir, "node_modules/.cache/melange")
-	template, err := createThemeTemplate(pagesDir, "_theme.html", "_theme.gohtml")
+	partials, err := loadPartials(pagesDir, options.Funcs)
+
+	if err != nil {
+		return Site{}, err
+	}
+
+	taxonomies := &taxonomyIndex{names: config.Taxonomies}
+	template, themePath, err := createThemeTemplate(pagesDir, withHTMLPartials(partials.html, sharedFuncs(options.Funcs, taxonomies)), defaultThemeHtml, "_theme.html", "_theme.gohtml")
+
+	if err != nil {
+		return Site{}, err
+	}
+
+	taxonomyTemplate, taxonomyPath, err := createThemeTemplate(pagesDir, withHTMLPartials(partials.html, sharedFuncs(options.Funcs, taxonomies)), defaultTaxonomyHtml, "_taxonomy.html", "_taxonomy.gohtml")
+
+	if err != nil {
+		return Site{}, err
+	}
+
+	framework, err := getFramework(frameworkName)
+
+	if err != nil {
+		return Site{}, err
+	}
+
+	hostRuntime, workers, err := siteRuntime(options)
 
 	if err != nil {
-		return config{}, err
-	}
-
-	return config{
-		production: production,
-		inputDir:   inputDir,
-		outputDir:  outputDir,
-		pagesDir:   pagesDir,
-		assetsDir:  assetsDir,
-		cacheDir:   cacheDir,
-		template:   template,
-		markdown:   createMarkdownRenderer(),
-		pages:      map[string]*page{},
-		framework:  preact,
+		return Site{}, err
+	}
+
+	return Site{
+		production:       options.Production,
+		funcs:            options.Funcs,
+		liveReload:       options.liveReload,
+		incremental:      options.incremental,
+		host:             options.host,
+		runtime:          hostRuntime,
+		workers:          workers,
+		inputDir:         inputDir,
+		outputDir:        outputDir,
+		pagesDir:         pagesDir,
+		assetsDir:        assetsDir,
+		cacheDir:         cacheDir,
+		template:         template,
+		themePath:        themePath,
+		partials:         partials,
+		markdown:         createMarkdownRenderer(config.Markdown.Extensions),
+		config:           config,
+		taxonomies:       taxonomies,
+		taxonomyTemplate: taxonomyTemplate,
+		taxonomyPath:     taxonomyPath,
+		pages:            map[string]*Page{},
+		framework:        framework,
 	}, nil
 }
 
-func createThemeTemplate(dir string, names ...string) (*template.Template, error) {
+// createThemeTemplate parses the first theme that exists in dir, falling
+// back to a default theme. It also returns the theme's path, which is empty
+// for the default theme.
+func createThemeTemplate(dir string, set *htmltemplate.Template, fallback string, names ...string) (*htmltemplate.Template, string, error) {
 	var html []byte
+	var themePath string
 
 	for _, name := range names {
-		html, _ = os.ReadFile(path.Join(dir, name))
+		themePath = path.Join(dir, name)
+		html, _ = os.ReadFile(themePath)
 		if html != nil {
 			break
 		}
 	}
 
 	if html == nil {
-		html = []byte(defaultThemeHtml)
+		html = []byte(fallback)
+		themePath = ""
 	}
 
-	template, err := template.New("page").Parse(string(html))
+	template, err := set.New("page").Parse(string(html))
 
 	if err != nil {
-		return nil, err
+		return nil, themePath, newTemplateError(TemplateParseError, themePath, err)
+	}
+
+	if err := checkContentsEscaping(template, themePath); err != nil {
+		return nil, themePath, err
 	}
 
-	return template, nil
+	return template, themePath, nil
 }
 
-func createMarkdownRenderer() goldmark.Markdown {
+// createMarkdownRenderer creates a renderer with the named extensions,
+// which have already been checked by validateConfig.
+func createMarkdownRenderer(extensions []string) goldmark.Markdown {
+	extenders := []goldmark.Extender{meta.Meta}
+
+	for _, name := range extensions {
+		extenders = append(extenders, markdownExtensions[name])
+	}
+
 	return goldmark.New(
-		goldmark.WithExtensions(
-			meta.Meta,
-			extension.GFM,
-			extension.Footnote,
-		),
+		goldmark.WithExtensions(extenders...),
 		goldmark.WithRendererOptions(
 			html.WithUnsafe(),
 		),
@@ -142,8 +407,8 @@ type crawldir struct {
 	depth int
 }
 
-func crawlSite(config *config) {
-	stack := []crawldir{{config.pagesDir, 0}}
+func crawlSite(site *Site) error {
+	stack := []crawldir{{site.pagesDir, 0}}
 
 	for len(stack) > 0 {
 		end := len(stack) - 1
@@ -152,19 +417,19 @@ func crawlSite(config *config) {
 		entries, err := ioutil.ReadDir(dir.name)
 
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 
 		for _, entry := range entries {
 			name := entry.Name()
 			absPath := path.Join(dir.name, name)
-			relPath := absPath[len(config.pagesDir):]
+			relPath := absPath[len(site.pagesDir):]
 
 			if shouldIgnore(name) {
 				continue
 			} else if entry.IsDir() {
 				stack = append(stack, crawldir{name: absPath, depth: dir.depth + 1})
-				config.directories = append(config.directories, relPath)
+				site.directories = append(site.directories, relPath)
 			} else if isPageFile(absPath) {
 				id := shortHash(absPath)
 				depth := dir.depth
@@ -174,33 +439,49 @@ func crawlSite(config *config) {
 					depth -= 1
 				}
 
-				config.pages[id] = &page{
-					id:      id,
-					dir:     dir.name,
-					depth:   depth,
-					absPath: absPath,
-					relPath: relPath,
-					Name:    name,
-					Url:     relPath,
+				site.pages[id] = &Page{
+					id:        id,
+					outputDir: site.outputDir,
+					dir:       dir.name,
+					depth:     depth,
+					absPath:   absPath,
+					relPath:   relPath,
+					Name:      name,
+					Url:       pageUrl(relPath),
 				}
 			} else {
-				config.assets = append(config.assets, &asset{
+				site.assets = append(site.assets, &asset{
 					absPath: absPath,
 					relPath: relPath,
 				})
 			}
 		}
 	}
+
+	if err := loadLayouts(site); err != nil {
+		return err
+	}
+
+	return loadData(site)
+}
+
+// pageUrl is the url that a page is served from.
+func pageUrl(relPath string) string {
+	url := strings.Replace(relPath, ".md", ".html", 1)
+	return strings.Replace(url, "index.html", "", 1)
 }
 
-func (page *page) addElement(src string, props props) *element {
+func (page *Page) addElement(src string, props props) *Element {
+	page.mu.Lock()
+	defer page.mu.Unlock()
 	hash := shortHash(fmt.Sprintf("%s%d", page.relPath, len(page.elements)))
 	id := fmt.Sprintf("$hydrate_%s", hash)
 	token := fmt.Sprintf("<!-- %s -->", id)
 
-	el := element{
+	el := Element{
 		id:    id,
 		src:   src,
+		dir:   page.dir,
 		ssr:   true,
 		csr:   false,
 		token: token,
@@ -211,7 +492,7 @@ func (page *page) addElement(src string, props props) *element {
 	return &el
 }
 
-func (e *element) String() string {
+func (e *Element) String() string {
 	if e.ssr && !e.csr {
 		return e.token
 	} else {
@@ -219,10 +500,28 @@ func (e *element) String() string {
 	}
 }
 
-func (config *config) getPageIndex(dir string) []*page {
-	var index []*page
+// trigger returns the start of a runtime call that defers hydration until
+// the element's directive fires, or an empty string if the element should be
+// hydrated immediately.
+func (e *Element) trigger() string {
+	if e.visible {
+		return fmt.Sprintf("onVisible(document.getElementById(\"%s\"), ", e.id)
+	} else if e.idle {
+		return "onIdle("
+	} else if e.media != "" {
+		query, _ := json.Marshal(e.media)
+		return fmt.Sprintf("onMedia(%s, ", query)
+	}
+
+	return ""
+}
+
+// getPageIndex returns the pages in a directory, and the index pages of its
+// subdirectories, sorted by path.
+func (site *Site) getPageIndex(dir string) []*Page {
+	var index []*Page
 
-	for _, page := range config.pages {
+	for _, page := range site.listedPages() {
 		if (page.dir == dir && page.Name != "index.md") ||
 			(path.Dir(page.dir) == dir && page.Name == "index.md") {
 			index = append(index, page)
@@ -240,47 +539,113 @@ func parseProps(kvs ...any) props {
 	return props
 }
 
-func readPage(p *page, config *config) error {
-	contents, err := os.ReadFile(p.absPath)
+// pageFuncs returns the funcs that are available to a page's template. The
+// user's funcs are included, but melange's own funcs take precedence.
+func pageFuncs(p *Page, site *Site) template.FuncMap {
+	templateFuncs := template.FuncMap{}
 
-	if err != nil {
-		log.Fatal(err)
+	for name, fn := range site.funcs {
+		templateFuncs[name] = fn
 	}
 
-	templateFuncs := template.FuncMap{
-		"render": func(entry string, args ...any) *element {
+	builtinFuncs := template.FuncMap{
+		"render": func(entry string, args ...any) *Element {
 			props := parseProps(args...)
 			el := p.addElement(entry, props)
+			el.framework = site.framework
 			return el
 		},
-		"client_load": func(element *element) *element {
+		"client_load": func(element *Element) *Element {
 			element.csr = true
 			element.ssr = true
 			return element
 		},
-		"client_only": func(element *element) *element {
+		"client_only": func(element *Element) *Element {
 			element.csr = true
 			element.ssr = false
 			return element
 		},
-		"pages": func() []*page {
-			return config.getPageIndex(p.dir)
+		"client_visible": func(element *Element) *Element {
+			element.csr = true
+			element.visible = true
+			return element
+		},
+		"client_idle": func(element *Element) *Element {
+			element.csr = true
+			element.idle = true
+			return element
 		},
+		"client_media": func(query string, element *Element) *Element {
+			element.csr = true
+			element.media = query
+			return element
+		},
+		"framework": func(name string, element *Element) (*Element, error) {
+			fw, err := getFramework(name)
+
+			if err != nil {
+				return nil, err
+			}
+
+			element.framework = fw
+			return element, nil
+		},
+		"pages": func() []*Page {
+			return site.getPageIndex(p.dir)
+		},
+		"taxonomy": func(name string) ([]*Term, error) {
+			return site.taxonomies.terms(name)
+		},
+		"paginate": func(items []*Page, size int) (*Paginator, error) {
+			return paginate(p, items, size)
+		},
+		"allPages": func() []*Page {
+			return site.listedPages()
+		},
+		"pagesIn": func(dir string) []*Page {
+			return site.getPagesIn(dir)
+		},
+	}
+
+	for name, fn := range builtinFuncs {
+		templateFuncs[name] = fn
 	}
 
-	tpl, err := template.New("page").Funcs(templateFuncs).Parse(string(contents))
+	return templateFuncs
+}
+
+// readPage reads a page's metadata and parses its template. Every page is
+// read before any are rendered, so templates always see complete metadata
+// for the pages they list.
+func readPage(p *Page, site *Site) error {
+	contents, err := os.ReadFile(p.absPath)
 
 	if err != nil {
 		return err
 	}
 
+	data, err := parseFrontMatter(contents)
+
+	if err != nil {
+		return newFrontMatterError(p.absPath, err)
+	}
+
+	p.Data = data
+	p.Title = pageTitle(data, contents, p.Name)
+
+	tpl, err := withTextPartials(site.partials.text, pageFuncs(p, site)).New("page").Parse(string(contents))
+
+	if err != nil {
+		return newTemplateError(TemplateParseError, p.absPath, err)
+	}
+
 	p.template = tpl
 	return nil
 }
 
-func readPages(config *config) error {
-	for _, page := range config.pages {
-		if err := readPage(page, config); err != nil {
+func readPages(site *Site) error {
+	for _, page := range site.pages {
+		if err := readPage(page, site); err != nil {
 			return err
 		}
 	}
@@ -289,62 +654,159 @@ func readPages(config *config) error {
 }
 
 type renderContext struct {
-	Page          *page
-	Config        *config
-	DefaultStyles string
+	Page          *Page
+	Site          *Site
+	DefaultStyles htmltemplate.CSS
+	// Taxonomy and Term are set for generated taxonomy pages.
+	Taxonomy *Taxonomy
+	Term     *Term
+	// Paginator is set once the page has called paginate.
+	Paginator *Paginator
 }
 
-func renderPage(page *page, config *config) error {
-	scope := renderContext{Page: page, Config: config, DefaultStyles: defaultThemeStyles}
+func renderPage(page *Page, site *Site) error {
+	scope := renderContext{Page: page, Site: site, DefaultStyles: htmltemplate.CSS(defaultThemeStyles)}
+
+	// 1. Render the page's contents. Generated pages don't have their own
+	// template, so they go straight to step 2.
+	if page.taxonomy != nil {
+		if err := renderTaxonomyPage(page, site, scope); err != nil {
+			return err
+		}
+	} else if err := renderMarkdown(page, site, scope); err != nil {
+		return err
+	}
+
+	scope.Paginator = page.paginator
+
+	// 2. Wrap the contents in the page's layouts.
+	if err := applyLayouts(page, site, scope); err != nil {
+		return err
+	}
+
+	// 3. Execute the theme template to render the complete page, with layout.
+	var buf bytes.Buffer
+	err := site.template.Execute(&buf, scope)
+
+	if err != nil {
+		return newPartialAwareError(site, TemplateExecError, site.themePath, err)
+	}
+
+	page.Contents = buf.String()
+	return nil
+}
 
-	// 1. Execute the page's own template. This is a markdown template that will
-	// handle any in-page templating.
+// renderMarkdown executes a page's own template, which is a markdown
+// template that handles any in-page templating, then converts the output
+// to HTML.
+func renderMarkdown(page *Page, site *Site, scope renderContext) error {
 	var pageBuf bytes.Buffer
 	err := page.template.Execute(&pageBuf, scope)
 
 	if err != nil {
-		return err
+		return newPartialAwareError(site, TemplateExecError, page.absPath, err)
 	}
 
-	// 2. Convert the output from the previous step to HTML.
 	var htmlbuf bytes.Buffer
-	ctx := parser.NewContext()
-	err = config.markdown.Convert(pageBuf.Bytes(), &htmlbuf, parser.WithContext(ctx))
+	err = site.markdown.Convert(pageBuf.Bytes(), &htmlbuf)
 
 	if err != nil {
-		return err
+		return &BuildError{Category: MarkdownError, File: page.absPath, Message: err.Error()}
 	}
 
-	page.Url = strings.Replace(page.Url, ".md", ".html", 1)
-	page.Url = strings.Replace(page.Url, "index.html", "", 1)
-	page.Data = meta.Get(ctx)
 	page.Contents = htmlbuf.String()
+	return nil
+}
 
-	// 3. Execute the theme template to render the complete page, with layout.
-	var buf bytes.Buffer
-	err = config.template.Execute(&buf, scope)
+// renderPages renders pages in waves, starting from the deepest pages, so
+// that the pages an index lists with "pages" are rendered before the index
+// itself. Their metadata is already known, but this means that their
+// contents are complete too. Pages that don't list other pages are rendered
+// concurrently in the first wave. Pages that do can read each other's
+// contents, so the rest of the waves are rendered one page at a time, with
+// the index pages of a wave before the pages that list them. Pages that list
+// pages with allPages, pagesIn or .Site.Pages can list any page, so they're
+// rendered after that, followed by the generated taxonomy pages.
+func renderPages(site *Site) error {
+	var independent, global, generated []*Page
+	waves := map[int][]*Page{}
+	var depths []int
+
+	for _, page := range site.Pages() {
+		if page.cached {
+			continue
+		}
 
-	if err != nil {
+		if page.taxonomy != nil {
+			generated = append(generated, page)
+			continue
+		}
+
+		if site.listsAllPages(page) {
+			global = append(global, page)
+			continue
+		}
+
+		if !site.listsPages(page) {
+			independent = append(independent, page)
+			continue
+		}
+
+		if waves[page.depth] == nil {
+			depths = append(depths, page.depth)
+		}
+
+		waves[page.depth] = append(waves[page.depth], page)
+	}
+
+	sort.Sort(sort.Reverse(sort.IntSlice(depths)))
+
+	if err := renderWave(site, independent, renderConcurrently); err != nil {
 		return err
 	}
 
-	page.Contents = buf.String()
-	return nil
+	for _, depth := range depths {
+		wave := waves[depth]
+
+		// Index pages at this depth only list deeper pages
+		sort.SliceStable(wave, func(i, j int) bool {
+			return path.Base(wave[i].relPath) == "index.md" && path.Base(wave[j].relPath) != "index.md"
+		})
+
+		if err := renderWave(site, wave, renderInOrder); err != nil {
+			return err
+		}
+	}
+
+	if err := renderWave(site, global, renderInOrder); err != nil {
+		return err
+	}
+
+	return renderWave(site, generated, renderConcurrently)
 }
 
-func renderPages(config *config) error {
-	pages := make([]*page, 0, len(config.pages))
+// renderWave renders a wave of pages, followed by the rest of the pages of
+// any that were paginated. The later pages aren't listed by any others, so
+// they're always rendered concurrently.
+func renderWave(site *Site, pages []*Page, render func(site *Site, pages []*Page) error) error {
+	if err := render(site, pages); err != nil {
+		return err
+	}
 
-	for _, page := range config.pages {
-		pages = append(pages, page)
+	paginated, err := addPaginatedPages(site, pages)
+
+	if err != nil {
+		return err
 	}
 
-	sort.SliceStable(pages, func(i, j int) bool {
-		return pages[i].depth > pages[j].depth
-	})
+	return renderConcurrently(site, paginated)
+}
 
+// renderInOrder renders pages one at a time, so that each page can read the
+// contents of the ones before it.
+func renderInOrder(site *Site, pages []*Page) error {
 	for _, page := range pages {
-		if err := renderPage(page, config); err != nil {
+		if err := renderPage(page, site); err != nil {
 			return err
 		}
 	}
@@ -352,146 +814,348 @@ func renderPages(config *config) error {
 	return nil
 }
 
-func writeSite(config *config) {
-	err := os.MkdirAll(config.outputDir, os.ModePerm)
+// renderConcurrently renders pages on a pool of goroutines. If any pages
+// fail, the error from the first of them is returned.
+func renderConcurrently(site *Site, pages []*Page) error {
+	errs := make([]error, len(pages))
+	queue := make(chan int)
+	var wg sync.WaitGroup
 
-	if err != nil {
-		log.Fatal(err)
+	for n := 0; n < runtime.GOMAXPROCS(0) && n < len(pages); n++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range queue {
+				errs[i] = renderPage(pages[i], site)
+			}
+		}()
 	}
 
-	for _, dir := range config.directories {
-		outdir := path.Join(config.outputDir, dir)
-		err := os.MkdirAll(outdir, os.ModePerm)
+	for i := range pages {
+		queue <- i
+	}
+
+	close(queue)
+	wg.Wait()
 
+	for _, err := range errs {
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 	}
 
-	for _, page := range config.pages {
-		outputPath := path.Join(config.outputDir, page.relPath)
-		outputPath = strings.Replace(outputPath, ".md", ".html", 1)
-		f, err := os.Create(outputPath)
+	return nil
+}
 
-		if err != nil {
-			log.Fatal(err)
+// usesFunc reports whether a template, or any template it defines, calls
+// the named func.
+func usesFunc(tpl *template.Template, name string) bool {
+	return treesMatch(textTrees(tpl), isFunc(name))
+}
+
+// listsAllPages reports whether a page's contents can list any page in the
+// site, with allPages, pagesIn or .Site.Pages, in its template or layouts.
+func (site *Site) listsAllPages(page *Page) bool {
+	trees := site.contentTrees(page)
+
+	return treesMatch(trees, isFunc("allPages")) ||
+		treesMatch(trees, isFunc("pagesIn")) ||
+		treesMatch(trees, isField("Site", "Pages"))
+}
+
+// listsPages reports whether a page's contents list the pages in its
+// directory with pages, in its template or layouts.
+func (site *Site) listsPages(page *Page) bool {
+	return treesMatch(site.contentTrees(page), isFunc("pages"))
+}
+
+// contentTrees returns the parse trees of the templates that render a page's
+// contents, which are its own template and its layouts.
+func (site *Site) contentTrees(page *Page) []*parse.Tree {
+	trees := textTrees(page.template)
+
+	// Unknown layouts are reported when the page is rendered
+	layouts, _ := site.layoutsFor(page)
+
+	for _, l := range layouts {
+		for _, t := range l.template.Templates() {
+			trees = append(trees, t.Tree)
 		}
+	}
 
-		defer f.Close()
+	return trees
+}
 
-		_, err = f.WriteString(page.Contents)
+func textTrees(tpl *template.Template) []*parse.Tree {
+	var trees []*parse.Tree
 
-		if err != nil {
-			log.Fatal(err)
+	if tpl != nil {
+		for _, t := range tpl.Templates() {
+			trees = append(trees, t.Tree)
 		}
 	}
 
-	for _, asset := range config.assets {
-		outputPath := path.Join(config.outputDir, asset.relPath)
+	return trees
+}
 
-		src, err := os.Open(asset.absPath)
+func treesMatch(trees []*parse.Tree, match func(parse.Node) bool) bool {
+	for _, tree := range trees {
+		if tree != nil && nodeMatches(tree.Root, match) {
+			return true
+		}
+	}
 
-		if err != nil {
-			log.Fatal(err)
+	return false
+}
+
+// isFunc matches calls to the named func.
+func isFunc(name string) func(parse.Node) bool {
+	return func(node parse.Node) bool {
+		ident, ok := node.(*parse.IdentifierNode)
+		return ok && ident.Ident == name
+	}
+}
+
+// isField matches reads of a chain of fields, like .Site.Pages or
+// $.Site.Pages.
+func isField(fields ...string) func(parse.Node) bool {
+	return func(node parse.Node) bool {
+		var idents []string
+
+		switch node := node.(type) {
+		case *parse.FieldNode:
+			idents = node.Ident
+		case *parse.VariableNode:
+			idents = node.Ident
+		case *parse.ChainNode:
+			idents = node.Field
 		}
 
-		dst, err := os.Create(outputPath)
+		return strings.Contains("."+strings.Join(idents, ".")+".", "."+strings.Join(fields, ".")+".")
+	}
+}
 
-		if err != nil {
-			log.Fatal(err)
+func nodeMatches(node parse.Node, match func(parse.Node) bool) bool {
+	if match(node) {
+		return true
+	}
+
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return false
 		}
 
-		_, err = io.Copy(dst, src)
+		for _, child := range node.Nodes {
+			if nodeMatches(child, match) {
+				return true
+			}
+		}
+	case *parse.ChainNode:
+		return nodeMatches(node.Node, match)
+	case *parse.ActionNode:
+		return nodeMatches(node.Pipe, match)
+	case *parse.TemplateNode:
+		return node.Pipe != nil && nodeMatches(node.Pipe, match)
+	case *parse.PipeNode:
+		if node == nil {
+			return false
+		}
 
-		if err != nil {
-			log.Fatal(err)
+		for _, cmd := range node.Cmds {
+			if nodeMatches(cmd, match) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range node.Args {
+			if nodeMatches(arg, match) {
+				return true
+			}
 		}
+	case *parse.IfNode:
+		return nodeMatches(&node.BranchNode, match)
+	case *parse.RangeNode:
+		return nodeMatches(&node.BranchNode, match)
+	case *parse.WithNode:
+		return nodeMatches(&node.BranchNode, match)
+	case *parse.BranchNode:
+		return nodeMatches(node.Pipe, match) ||
+			nodeMatches(node.List, match) ||
+			(node.ElseList != nil && nodeMatches(node.ElseList, match))
 	}
+
+	return false
 }
 
-func Build(dir string, prod bool) (*config, error) {
-	start := time.Now()
-	config, err := createConfig(dir, prod)
+func writeSite(site *Site) error {
+	err := os.MkdirAll(site.outputDir, os.ModePerm)
 
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if prod {
-		os.RemoveAll(config.cacheDir)
-		os.RemoveAll(config.outputDir)
+	for _, dir := range site.directories {
+		outdir := path.Join(site.outputDir, dir)
+		err := os.MkdirAll(outdir, os.ModePerm)
+
+		if err != nil {
+			return err
+		}
 	}
 
-	crawlSite(&config)
+	for _, page := range site.pages {
+		if !site.cache.pageChanged(page) {
+			continue
+		}
 
-	if err := readPages(&config); err != nil {
-		return nil, err
-	}
+		err := os.WriteFile(page.OutputPath(), []byte(page.Contents), os.ModePerm)
 
-	if err := renderPages(&config); err != nil {
-		return nil, err
+		if err != nil {
+			return err
+		}
 	}
 
-	if err := bundle(&config); err != nil {
-		return nil, err
+	for _, asset := range site.assets {
+		if !site.cache.assetChanged(site, asset) {
+			continue
+		}
+
+		if err := copyFile(asset.absPath, path.Join(site.outputDir, asset.relPath)); err != nil {
+			return err
+		}
 	}
 
-	writeSite(&config)
-	fmt.Printf("built site in %s\n", time.Since(start))
-	return &config, nil
+	return nil
 }
 
-func Serve(dir string, prod bool) {
-	config, err := Build(dir, prod)
+func copyFile(srcPath string, dstPath string) error {
+	src, err := os.Open(srcPath)
 
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	fs := http.FileServer(http.Dir(config.outputDir))
+	defer src.Close()
+	dst, err := os.Create(dstPath)
 
-	http.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Rebuild the site whenever an HTML file is requested
-		if r.URL.Path == "/" || strings.HasSuffix(r.URL.Path, ".html") {
-			config, err = Build(dir, prod)
-		}
+	if err != nil {
+		return err
+	}
 
-		if err != nil {
-			http.Error(w, "Build failed", 500)
-			log.Println(err)
-		}
+	defer dst.Close()
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// BuildOptions configures a build of a site.
+type BuildOptions struct {
+	// Dir is the root directory of the site, containing the pages directory.
+	// Defaults to the current working directory.
+	Dir string
+
+	// Production builds produce minified assets with hashed names.
+	Production bool
 
-		fs.ServeHTTP(w, r)
-	}))
+	// Framework is the default framework for rendering components. Defaults
+	// to the framework in the site's config, or "preact".
+	Framework string
 
-	fmt.Println("serving site at http://localhost:8000...")
-	err = http.ListenAndServe(":8000", nil)
+	// Funcs are additional template funcs that are available to pages and
+	// themes. Melange's own funcs take precedence over these.
+	Funcs template.FuncMap
+
+	// Runtime is the JavaScript runtime that renders components on the
+	// server. One of "node", "bun", "deno" or "goja", which is built into
+	// melange and doesn't need any external programs. Defaults to "node".
+	Runtime string
+
+	// Workers is the number of runtime processes that render components in
+	// parallel. Defaults to the number of CPUs.
+	Workers int
+
+	// liveReload injects the dev server's client into every page.
+	liveReload bool
+
+	// incremental reuses the client bundler between builds.
+	incremental *incrementalBuild
+
+	// host renders components. Builds start and stop their own host unless
+	// one is provided.
+	host renderer
+}
+
+// Result describes a completed build.
+type Result struct {
+	Site     *Site
+	Pages    []*Page
+	Duration time.Duration
+}
+
+// Build renders the site described by options into its output directory.
+func Build(options BuildOptions) (*Result, error) {
+	start := time.Now()
+	site, err := createSite(options)
 
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-}
 
-func main() {
-	var cwd string
-	var serve bool
+	if site.host == nil {
+		site.host = newRenderer(site.runtime, site.workers)
+		defer site.host.Close()
+	}
 
-	flag.BoolVar(&serve, "serve", false, "serve the site and rebuild for each request")
-	flag.StringVar(&cwd, "cwd", "", "cwd of your site")
-	flag.Parse()
+	// Bundles are built from scratch every time, so stale ones are removed
+	if site.production {
+		os.RemoveAll(site.assetsDir)
+	}
 
-	if cwd != "" {
-		err := os.Chdir(cwd)
-		if err != nil {
-			log.Fatal(err)
+	if err := crawlSite(&site); err != nil {
+		return nil, err
+	}
+
+	if err := readPages(&site); err != nil {
+		return nil, err
+	}
+
+	loadTaxonomies(&site)
+
+	site.cache = loadBuildCache(&site)
+
+	if err := restorePages(&site); err != nil {
+		return nil, err
+	}
+
+	if err := renderPages(&site); err != nil {
+		return nil, err
+	}
+
+	if err := bundle(&site); err != nil {
+		return nil, err
+	}
+
+	if site.liveReload {
+		for _, page := range site.pages {
+			page.injectHead(devClientScript)
 		}
 	}
 
-	inputDir, _ := os.Getwd()
+	if err := writeSite(&site); err != nil {
+		return nil, err
+	}
 
-	if serve {
-		Serve(inputDir, false)
-	} else {
-		Build(inputDir, true)
+	site.cache.removeStale(&site)
+
+	if err := site.cache.save(); err != nil {
+		return nil, err
 	}
+
+	return &Result{
+		Site:     &site,
+		Pages:    site.Pages(),
+		Duration: time.Since(start),
+	}, nil
 }
@@ -1,6 +1,13 @@
-package main
+package melange
 
-import "testing"
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"text/template"
+)
 
 func TestShouldIgnore(t *testing.T) {
 	tests := map[string]bool{
@@ -16,3 +23,165 @@ func TestShouldIgnore(t *testing.T) {
 		}
 	}
 }
+
+func createTestSite(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+
+	for name, contents := range files {
+		file := path.Join(dir, name)
+		os.MkdirAll(path.Dir(file), os.ModePerm)
+
+		if err := os.WriteFile(file, []byte(contents), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestBuild(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"pages/index.md":       "---\ntitle: Home\n---\n{{ shout \"hello\" }}",
+		"pages/posts/first.md": "---\ntitle: First\n---\nFirst post",
+		"pages/_ignored.md":    "Ignored",
+	})
+
+	result, err := Build(BuildOptions{
+		Dir: dir,
+		Funcs: template.FuncMap{
+			"shout": strings.ToUpper,
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Pages) != 2 {
+		t.Fatalf("expected 2 pages, got %d", len(result.Pages))
+	}
+
+	if result.Pages[0].Path() != "/index.md" || result.Pages[1].Path() != "/posts/first.md" {
+		t.Fatalf("expected pages to be sorted by path")
+	}
+
+	html, err := os.ReadFile(path.Join(dir, "_site/index.html"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(html), "HELLO") {
+		t.Fatalf("expected custom template funcs to be called:\n%s", html)
+	}
+
+	if result.Pages[1].Data["title"] != "First" {
+		t.Fatalf("expected front matter to be parsed")
+	}
+}
+
+func TestBuildUnknownFramework(t *testing.T) {
+	dir := createTestSite(t, map[string]string{"pages/index.md": "Hello"})
+	_, err := Build(BuildOptions{Dir: dir, Framework: "angular"})
+
+	if err == nil {
+		t.Fatal("expected unknown frameworks to fail the build")
+	}
+}
+
+func TestUsesFunc(t *testing.T) {
+	tests := map[string]bool{
+		"{{ range pages }}{{ .Url }}{{ end }}":            true,
+		"{{ if true }}{{ else }}{{ len pages }}{{ end }}": true,
+		"{{ define \"x\" }}{{ $p := pages }}{{ end }}":    true,
+		"{{ .Page.Url }} pages":                           false,
+	}
+
+	for source, expected := range tests {
+		tpl := template.Must(template.New("page").Funcs(template.FuncMap{"pages": func() []*Page { return nil }}).Parse(source))
+
+		if usesFunc(tpl, "pages") != expected {
+			t.Fatalf("expected usesFunc to be %t for %q", expected, source)
+		}
+	}
+}
+
+func TestIsField(t *testing.T) {
+	tests := map[string]bool{
+		"{{ range .Site.Pages }}{{ end }}":           true,
+		"{{ len $.Site.Pages }}":                     true,
+		"{{ with .Page }}{{ .Site.Pages }}{{ end }}": true,
+		"{{ .Site.Title }} {{ .Page.Pages }}":        false,
+	}
+
+	for source, expected := range tests {
+		tpl := template.Must(template.New("page").Parse(source))
+
+		if treesMatch(textTrees(tpl), isField("Site", "Pages")) != expected {
+			t.Fatalf("expected isField to be %t for %q", expected, source)
+		}
+	}
+}
+
+func TestBuildRendersChildrenBeforeIndexes(t *testing.T) {
+	listing := "{{ range pages }}{{ .Contents }}{{ end }}"
+
+	files := map[string]string{
+		"pages/_theme.html":   `{{ .Page.Contents | safeHTML }}`,
+		"pages/index.md":      listing,
+		"pages/about.md":      listing,
+		"pages/blog/index.md": listing,
+		"pages/archive.md":    "{{ range .Site.Pages }}{{ .Contents }}{{ end }}",
+	}
+
+	for i := 0; i < 20; i++ {
+		files[fmt.Sprintf("pages/blog/post-%d.md", i)] = fmt.Sprintf("Post %d", i)
+	}
+
+	dir := createTestSite(t, files)
+
+	if _, err := Build(BuildOptions{Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each page sees the complete contents of the pages it lists, including
+	// about.md, which lists blog/index.md from the same wave
+	expected := map[string]int{
+		"_site/blog/index.html": 20,
+		"_site/about.html":      20,
+		"_site/index.html":      40,
+		"_site/archive.html":    100,
+	}
+
+	for file, count := range expected {
+		html, _ := os.ReadFile(path.Join(dir, file))
+
+		if n := strings.Count(string(html), "<p>Post "); n != count {
+			t.Fatalf("expected %s to include %d posts, got %d:\n%s", file, count, n, html)
+		}
+	}
+}
+
+func TestThemeEscaping(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"pages/index.md": "---\ntitle: <script>alert(1)</script>\n---\n<b>bold</b>",
+	})
+
+	if _, err := Build(BuildOptions{Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	html, _ := os.ReadFile(path.Join(dir, "_site/index.html"))
+
+	if strings.Contains(string(html), "<script>alert(1)</script>") {
+		t.Fatalf("expected front matter to be escaped in the theme:\n%s", html)
+	}
+
+	if !strings.Contains(string(html), "<h1>&lt;script&gt;alert(1)&lt;/script&gt;</h1>") {
+		t.Fatalf("expected escaped title:\n%s", html)
+	}
+
+	if !strings.Contains(string(html), "<b>bold</b>") {
+		t.Fatalf("expected page contents to be unescaped:\n%s", html)
+	}
+}
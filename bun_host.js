@@ -0,0 +1,72 @@
+let [sockAddr] = process.argv.slice(2);
+
+function serializeError(err) {
+  if (err instanceof Error) {
+    return { message: err.message, stack: err.stack || "" };
+  } else {
+    return { message: String(err), stack: "" };
+  }
+}
+
+// Each element is rendered separately, so that one broken component can't
+// stop the others from rendering.
+async function renderElements(requirePath) {
+  let response = { html: {}, errors: {} };
+
+  try {
+    delete require.cache[requirePath];
+    let elements = require(requirePath);
+
+    await Promise.all(
+      Object.entries(elements).map(async ([id, render]) => {
+        try {
+          response.html[id] = await render();
+        } catch (err) {
+          response.errors[id] = serializeError(err);
+        }
+      })
+    );
+  } catch (err) {
+    response.error = serializeError(err);
+  }
+
+  return response;
+}
+
+// Messages are framed as a 4 byte big-endian length followed by JSON.
+function send(socket, message) {
+  let body = Buffer.from(JSON.stringify(message));
+  let header = Buffer.alloc(4);
+  header.writeUInt32BE(body.length);
+  socket.write(Buffer.concat([header, body]));
+}
+
+let buffer = Buffer.alloc(0);
+
+await Bun.connect({
+  unix: sockAddr,
+  socket: {
+    async data(socket, chunk) {
+      buffer = Buffer.concat([buffer, chunk]);
+
+      while (buffer.length >= 4) {
+        let length = buffer.readUInt32BE(0);
+
+        if (buffer.length < 4 + length) {
+          break;
+        }
+
+        let request = JSON.parse(buffer.subarray(4, 4 + length).toString());
+        buffer = buffer.subarray(4 + length);
+        renderElements(request.path).then(result => send(socket, { id: request.id, result }));
+      }
+    },
+    // Exit with the process that started us
+    close() {
+      process.exit(0);
+    },
+    error() {
+      process.exit(1);
+    },
+  },
+});
@@ -1,15 +1,18 @@
-package main
+package melange
 
 import (
-	"errors"
+	_ "embed"
 	"fmt"
-	"log"
 	"path"
 	"strings"
+	"sync"
 
 	"github.com/evanw/esbuild/pkg/api"
 )
 
+//go:embed client_runtime.js
+var clientRuntimeJs string
+
 var loader = map[string]api.Loader{
 	".aac":         api.LoaderFile,
 	".css":         api.LoaderFile,
@@ -39,78 +42,221 @@ var loader = map[string]api.Loader{
 	".woff2":       api.LoaderFile,
 }
 
-func bundle(config *config) error {
-	if err := createStaticBundle(config); err != nil {
+func bundle(site *Site) error {
+	if err := preflight(site); err != nil {
 		return err
 	}
 
-	if err := createClientBundles(config); err != nil {
+	if err := createStaticBundle(site); err != nil {
+		return err
+	}
+
+	// Pages are cached before their scripts are injected, because the client
+	// bundles are built again every time
+	recordPages(site)
+
+	if err := createClientBundles(site); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func createStaticBundle(config *config) error {
-	contents := config.framework.staticBundle(config)
-	outfile := path.Join(config.cacheDir, "static-bundle.js")
+// staticChunk is a group of pages whose elements are rendered by the same
+// static bundle.
+type staticChunk struct {
+	name   string
+	groups map[*framework][]*Element
+}
+
+// splitStaticChunks spreads the pages with server rendered elements across
+// at most count chunks, so that they can be rendered in parallel.
+func splitStaticChunks(site *Site, count int) []*staticChunk {
+	var chunks []*staticChunk
+	var elements [][]*Element
+	i := 0
+
+	for _, page := range site.Pages() {
+		var pageElements []*Element
+
+		if page.cached {
+			continue
+		}
+
+		for _, element := range page.elements {
+			if element.ssr {
+				pageElements = append(pageElements, element)
+			}
+		}
+
+		if len(pageElements) == 0 {
+			continue
+		}
+
+		if len(elements) < count {
+			elements = append(elements, nil)
+		}
+
+		n := i % count
+		elements[n] = append(elements[n], pageElements...)
+		i++
+	}
+
+	for n, chunkElements := range elements {
+		chunks = append(chunks, &staticChunk{
+			name:   fmt.Sprintf("chunk-%d", n),
+			groups: groupByFramework(chunkElements),
+		})
+	}
+
+	return chunks
+}
+
+func createStaticBundle(site *Site) error {
+	chunks := splitStaticChunks(site, site.workers*4)
+
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	external := []string{}
+	var entryPoints []api.EntryPoint
+	var fws []*framework
+
+	for _, chunk := range chunks {
+		fws = append(fws, sortedFrameworks(chunk.groups)...)
+
+		entryPoints = append(entryPoints, api.EntryPoint{
+			InputPath:  fmt.Sprintf("static:%s", chunk.name),
+			OutputPath: chunk.name,
+		})
+	}
+
+	fws = uniqueFrameworks(fws)
+	define := map[string]string{}
+
+	// Runtimes that can't require packages at runtime get them bundled. They
+	// don't have a process global either, which packages like react and vue
+	// read their mode from.
+	if site.runtime.external {
+		for _, fw := range fws {
+			external = append(external, fw.staticExternal...)
+		}
+	} else if site.production {
+		define["process.env.NODE_ENV"] = `"production"`
+	} else {
+		define["process.env.NODE_ENV"] = `"development"`
+	}
+
+	outdir := path.Join(site.cacheDir, "static")
 
 	result := api.Build(api.BuildOptions{
-		Stdin: &api.StdinOptions{
-			Contents:   contents,
-			ResolveDir: config.inputDir,
-			Sourcefile: "static-bundle.js",
-			Loader:     api.LoaderJS,
-		},
-		Write:       true,
-		Bundle:      true,
-		Metafile:    true,
-		Sourcemap:   api.SourceMapExternal,
-		Outfile:     outfile,
-		Platform:    api.PlatformNode,
-		Format:      api.FormatCommonJS,
-		External:    config.framework.staticExternal,
-		Incremental: !config.production,
-		Loader:      loader,
-		PublicPath:  strings.TrimPrefix(config.assetsDir, config.outputDir),
+		EntryPointsAdvanced: entryPoints,
+		Outdir:              outdir,
+		Write:               true,
+		Bundle:              true,
+		Metafile:            true,
+		Sourcemap:           api.SourceMapExternal,
+		Platform:            api.PlatformNode,
+		Format:              site.runtime.format,
+		External:            external,
+		Define:              define,
+		Loader:              loader,
+		AbsWorkingDir:       site.inputDir,
+		Plugins: append(
+			[]api.Plugin{staticChunksPlugin(site, chunks)},
+			frameworkPlugins(site, fws, true)...,
+		),
+		PublicPath: strings.TrimPrefix(site.assetsDir, site.outputDir),
 	})
 
 	if len(result.Errors) > 0 {
-		for _, err := range result.Errors {
-			log.Println(err)
+		return newBundlerErrors(site, result.Errors)
+	}
+
+	// Each chunk is rendered by whichever worker is free
+	responses := make([]*renderResponse, len(chunks))
+	errs := make([]error, len(chunks))
+	var wg sync.WaitGroup
+
+	for i, chunk := range chunks {
+		wg.Add(1)
+
+		go func(i int, outfile string) {
+			defer wg.Done()
+			response, err := site.host.render(outfile)
+
+			if err != nil {
+				errs[i] = &BuildError{Category: RuntimeError, File: outfile, Message: err.Error()}
+			} else {
+				responses[i] = response
+				errs[i] = response.buildErrors(site, outfile)
+			}
+		}(i, path.Join(outdir, chunk.name+".js"))
+	}
+
+	wg.Wait()
+
+	var buildErrs BuildErrors
+
+	for _, err := range errs {
+		if errs := AsBuildErrors(err); errs != nil {
+			buildErrs = append(buildErrs, errs...)
 		}
+	}
 
-		return errors.New("bundler failed")
+	if len(buildErrs) > 0 {
+		return buildErrs
 	}
 
-	var renderedHtml map[string]string
-	// TODO: This is a huge bottleneck (waiting for node to start)
-	//renderedHtmlJson, err := exec.Command("node", outfile).Output()
-	err := nodeExecFile(outfile, &renderedHtml)
+	html := map[string]string{}
 
-	if err != nil {
-		return fmt.Errorf("node exec failed: %s", err)
+	for _, response := range responses {
+		for id, elementHtml := range response.Html {
+			html[id] = elementHtml
+		}
 	}
 
-	for _, page := range config.pages {
+	for _, page := range site.pages {
+		if page.cached {
+			continue
+		}
+
 		for _, element := range page.elements {
-			html := renderedHtml[element.id]
-			page.Contents = strings.Replace(page.Contents, element.token, html, -1)
+			page.Contents = strings.Replace(page.Contents, element.token, html[element.id], -1)
 		}
 	}
 
-	return nil
+	return readStaticInputs(site, result.Metafile)
 }
 
-func createClientBundles(config *config) error {
+func createClientBundles(site *Site) error {
 	var entryPoints []api.EntryPoint
+	var elements []*Element
 
-	for _, page := range config.pages {
+	for _, page := range site.pages {
+		for _, element := range page.elements {
+			if element.csr {
+				elements = append(elements, element)
+			}
+		}
+	}
+
+	fws := sortedFrameworks(groupByFramework(elements))
+	define := map[string]string{}
+
+	for _, fw := range fws {
+		for key, value := range fw.esbuildOptions.Define {
+			define[key] = value
+		}
+	}
+
+	for _, page := range site.pages {
 		for _, element := range page.elements {
 			if element.csr {
 				name := page.id
 
-				if !config.production {
+				if !site.production {
 					name = slugify(page.relPath) + page.id
 				}
 
@@ -125,60 +271,79 @@ func createClientBundles(config *config) error {
 
 	entryNames := "[name]"
 
-	if config.production {
+	if site.production {
 		entryNames = "[name]-[hash]"
 	}
 
-	result := api.Build(api.BuildOptions{
+	// Lazily hydrated elements are split into their own chunks, so that their
+	// code isn't fetched until they are needed.
+	chunkNames := "chunks/[name]-[hash]"
+
+	options := api.BuildOptions{
 		EntryPointsAdvanced: entryPoints,
 		EntryNames:          entryNames,
-		Outdir:              config.assetsDir,
+		ChunkNames:          chunkNames,
+		Outdir:              site.assetsDir,
 		Write:               true,
 		Bundle:              true,
 		Metafile:            true,
 		Sourcemap:           api.SourceMapExternal,
-		MinifyWhitespace:    config.production,
-		MinifyIdentifiers:   config.production,
-		MinifySyntax:        config.production,
-		Incremental:         !config.production,
+		MinifyWhitespace:    site.production,
+		MinifyIdentifiers:   site.production,
+		MinifySyntax:        site.production,
+		Incremental:         site.incremental != nil,
 		Platform:            api.PlatformBrowser,
-		Format:              api.FormatIIFE,
-		Plugins:             []api.Plugin{hydratePagesPlugin(config)},
-		PublicPath:          strings.TrimPrefix(config.assetsDir, config.outputDir),
-		Loader:              loader,
-	})
+		Format:              api.FormatESModule,
+		Splitting:           true,
+		Define:              define,
+		Plugins: append(
+			[]api.Plugin{hydratePagesPlugin(site), clientRuntimePlugin()},
+			frameworkPlugins(site, fws, false)...,
+		),
+		PublicPath:    strings.TrimPrefix(site.assetsDir, site.outputDir),
+		Loader:        loader,
+		AbsWorkingDir: site.inputDir,
+	}
 
-	if len(result.Errors) > 0 {
-		for _, err := range result.Errors {
-			id := strings.ReplaceAll(err.Location.File, "page:", "")
-			page := config.pages[id]
-			fmt.Printf("%s in %s\n", err.Text, page.relPath)
-		}
+	var result api.BuildResult
 
-		return errors.New("bundler failed")
+	if site.incremental != nil {
+		result = site.incremental.build(options, clientBundlesKey(site))
+	} else {
+		result = api.Build(options)
+	}
+
+	if len(result.Errors) > 0 {
+		return newBundlerErrors(site, result.Errors)
 	}
 
-	for _, page := range config.pages {
+	for _, page := range site.pages {
 		scripts := []string{}
 		styles := []string{}
 
 		for _, file := range result.OutputFiles {
+			// Chunks are imported by the entry points that need them
+			if path.Dir(file.Path) != site.assetsDir {
+				continue
+			}
+
 			if strings.Contains(file.Path, page.id) {
 				ext := path.Ext(file.Path)
-				relpath := file.Path[len(config.outputDir):]
+				relpath := file.Path[len(site.outputDir):]
+
+				// Source maps and files that the bundles reference aren't linked
+				// from the page
 				switch ext {
 				case ".js":
 					scripts = append(scripts, relpath)
 				case ".css":
 					styles = append(styles, relpath)
-				case ".map":
-					// do nothing
-				default:
-					fmt.Printf("unrecognised outfile extension %s\n", ext)
 				}
 			}
 		}
 
+		page.scripts = scripts
+
 		if len(scripts) == 0 && len(styles) == 0 {
 			continue
 		}
@@ -191,20 +356,34 @@ func createClientBundles(config *config) error {
 		}
 
 		for _, src := range scripts {
-			inject.WriteString(fmt.Sprintf(`<script defer src="%s"></script>`, src))
+			inject.WriteString(fmt.Sprintf(`<script type="module" src="%s"></script>`, src))
 			inject.WriteByte('\n')
 		}
 
-		inject.WriteString("</head>")
+		page.injectHead(inject.String())
+	}
 
-		page.Contents = strings.Replace(page.Contents, "</head>", inject.String(), 1)
+	if !site.production {
+		return readClientInputs(site, result.Metafile)
 	}
 
 	return nil
 }
 
-func hydratePagesPlugin(config *config) api.Plugin {
-	filter := "page:"
+// pageFromModule finds the page that a virtual page module belongs to. Page
+// modules are named "page:<id>" or "page:<id>:<framework>".
+func (site *Site) pageFromModule(name string) *Page {
+	for _, part := range strings.Split(name, ":") {
+		if page, ok := site.pages[part]; ok {
+			return page
+		}
+	}
+
+	return nil
+}
+
+func hydratePagesPlugin(site *Site) api.Plugin {
+	filter := "^page:"
 	namespace := "page"
 
 	return api.Plugin{
@@ -223,14 +402,147 @@ func hydratePagesPlugin(config *config) api.Plugin {
 				Filter:    filter,
 				Namespace: namespace,
 			}, func(args api.OnLoadArgs) (api.OnLoadResult, error) {
-				id := strings.Replace(args.Path, filter, "", 1)
-				page := config.pages[id]
-				contents := config.framework.clientBundle(page)
+				parts := strings.Split(args.Path, ":")
+				page := site.pages[parts[1]]
+				var elements []*Element
+
+				for _, element := range page.elements {
+					if element.csr {
+						elements = append(elements, element)
+					}
+				}
+
+				groups := groupByFramework(elements)
+				var contents string
+				var tag string
+
+				if len(parts) == 2 {
+					// The page's entry point imports a separate module for each of the
+					// frameworks that it uses.
+					var builder strings.Builder
 
+					for _, fw := range sortedFrameworks(groups) {
+						builder.WriteString(fmt.Sprintf("import \"page:%s:%s\";\n", page.id, fw.name))
+					}
+
+					contents = builder.String()
+				} else {
+					fw, err := getFramework(parts[2])
+
+					if err != nil {
+						return api.OnLoadResult{}, err
+					}
+
+					contents = fw.clientBundle(groups[fw])
+					tag = fw.name
+				}
+
+				// Imports are tagged with the framework that they belong to, so that
+				// compile plugins know which modules to handle.
 				return api.OnLoadResult{
 					Contents:   &contents,
 					Loader:     api.LoaderJS,
 					ResolveDir: path.Dir(page.absPath),
+					PluginData: tag,
+				}, nil
+			})
+		},
+	}
+}
+
+// staticChunksPlugin generates the entry point for each static chunk, which
+// imports a separate module for each of the frameworks that it uses. These
+// are named "static:<chunk>" and "framework:<framework>:<chunk>".
+func staticChunksPlugin(site *Site, chunks []*staticChunk) api.Plugin {
+	filter := "^(static|framework):"
+	namespace := "static"
+	chunksByName := map[string]*staticChunk{}
+
+	for _, chunk := range chunks {
+		chunksByName[chunk.name] = chunk
+	}
+
+	return api.Plugin{
+		Name: "static-chunks",
+		Setup: func(build api.PluginBuild) {
+			build.OnResolve(api.OnResolveOptions{
+				Filter: filter,
+			}, func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+				return api.OnResolveResult{
+					Path:      args.Path,
+					Namespace: namespace,
+				}, nil
+			})
+
+			build.OnLoad(api.OnLoadOptions{
+				Filter:    filter,
+				Namespace: namespace,
+			}, func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+				parts := strings.Split(args.Path, ":")
+				chunk := chunksByName[parts[len(parts)-1]]
+				var contents, tag string
+
+				if parts[0] == "static" {
+					var builder strings.Builder
+					var names []string
+
+					for _, fw := range sortedFrameworks(chunk.groups) {
+						name := slugify(fw.name)
+						names = append(names, name)
+						builder.WriteString(fmt.Sprintf("import %s from \"framework:%s:%s\";\n", name, fw.name, chunk.name))
+					}
+
+					if site.runtime.format == api.FormatESModule {
+						builder.WriteString(fmt.Sprintf("export default Object.assign({}, %s);\n", strings.Join(names, ", ")))
+					} else {
+						builder.WriteString(fmt.Sprintf("module.exports = Object.assign({}, %s);\n", strings.Join(names, ", ")))
+					}
+					contents = builder.String()
+				} else {
+					fw, err := getFramework(parts[1])
+
+					if err != nil {
+						return api.OnLoadResult{}, err
+					}
+
+					contents = fw.staticBundle(chunk.groups[fw])
+					tag = fw.name
+				}
+
+				return api.OnLoadResult{
+					Contents:   &contents,
+					Loader:     api.LoaderJS,
+					ResolveDir: site.inputDir,
+					PluginData: tag,
+				}, nil
+			})
+		},
+	}
+}
+
+func clientRuntimePlugin() api.Plugin {
+	filter := "^melange:runtime$"
+	namespace := "melange"
+
+	return api.Plugin{
+		Name: "client-runtime",
+		Setup: func(build api.PluginBuild) {
+			build.OnResolve(api.OnResolveOptions{
+				Filter: filter,
+			}, func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+				return api.OnResolveResult{
+					Path:      args.Path,
+					Namespace: namespace,
+				}, nil
+			})
+
+			build.OnLoad(api.OnLoadOptions{
+				Filter:    filter,
+				Namespace: namespace,
+			}, func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+				return api.OnLoadResult{
+					Contents: &clientRuntimeJs,
+					Loader:   api.LoaderJS,
 				}, nil
 			})
 		},
@@ -0,0 +1,446 @@
+package melange
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// cacheVersion is bumped whenever the format of the cache changes, or the
+// way that pages are rendered changes.
+const cacheVersion = 3
+
+// buildCache is kept in the cache directory between builds, so that a
+// rebuild only renders the pages that are affected by what changed, and only
+// copies the assets that changed.
+//
+// A page is rendered again if its source changed, if the theme or the
+// build's options changed, if any of the files that went into its server
+// rendered elements changed, or if it lists pages with "pages", "allPages"
+// or "pagesIn" and any of those changed.
+type buildCache struct {
+	Version int                     `json:"version"`
+	Key     string                  `json:"key"`
+	Pages   map[string]*cachedPage  `json:"pages"`
+	Assets  map[string]*cachedAsset `json:"assets"`
+
+	file   string
+	hashes map[string]string
+}
+
+// cachedPage is a page as it was after its elements were rendered, and
+// before its scripts were injected, because the client bundles are built
+// again every time.
+type cachedPage struct {
+	Key        string           `json:"key"`
+	Contents   string           `json:"contents"`
+	Elements   []*cachedElement `json:"elements"`
+	Inputs     []string         `json:"inputs"`
+	Output     string           `json:"output"`
+	OutputPath string           `json:"outputPath"`
+}
+
+type cachedElement struct {
+	Id        string `json:"id"`
+	Src       string `json:"src"`
+	Dir       string `json:"dir"`
+	Framework string `json:"framework"`
+	Csr       bool   `json:"csr"`
+	Ssr       bool   `json:"ssr"`
+	Visible   bool   `json:"visible"`
+	Idle      bool   `json:"idle"`
+	Media     string `json:"media"`
+	Props     props  `json:"props"`
+}
+
+type cachedAsset struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// loadBuildCache reads the cache from the previous build with the same
+// mode. A missing or outdated cache is replaced by an empty one.
+func loadBuildCache(site *Site) *buildCache {
+	mode := "development"
+
+	if site.production {
+		mode = "production"
+	}
+
+	cache := &buildCache{
+		file:   path.Join(site.cacheDir, fmt.Sprintf("build-%s.json", mode)),
+		hashes: map[string]string{},
+	}
+
+	key := cache.siteKey(site)
+
+	if data, err := os.ReadFile(cache.file); err == nil {
+		json.Unmarshal(data, cache)
+	}
+
+	if cache.Version != cacheVersion || cache.Pages == nil || cache.Assets == nil {
+		cache.Pages = map[string]*cachedPage{}
+		cache.Assets = map[string]*cachedAsset{}
+	} else if cache.Key != key {
+		// Every page has to be rendered again, but their outputs are still
+		// known, so that the outputs of removed pages can be cleaned up.
+		for _, entry := range cache.Pages {
+			entry.Key = ""
+		}
+	}
+
+	cache.Version = cacheVersion
+	cache.Key = key
+	return cache
+}
+
+// siteKey identifies everything that every page depends on.
+func (cache *buildCache) siteKey(site *Site) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%d\n%s\n%s\n", cacheVersion, site.framework.name, site.runtime.name)
+
+	if site.themePath == "" {
+		fmt.Fprintln(h, shortHash(defaultThemeHtml))
+	} else {
+		fmt.Fprintln(h, cache.fileHash(site.themePath))
+	}
+
+	// Layouts, partials and data files can be used by any page
+	var layouts []string
+
+	for _, l := range site.layouts {
+		layouts = append(layouts, l.path)
+	}
+
+	for _, l := range site.namedLayouts {
+		layouts = append(layouts, l.path)
+	}
+
+	for _, file := range site.partials.files {
+		layouts = append(layouts, file)
+	}
+
+	layouts = append(layouts, site.dataFiles...)
+
+	// The config changes how markdown is rendered
+	if site.config.file != "" {
+		layouts = append(layouts, site.config.file)
+	}
+
+	sort.Strings(layouts)
+
+	for _, file := range layouts {
+		fmt.Fprintf(h, "%s %s\n", file, cache.fileHash(file))
+	}
+
+	var funcs []string
+
+	// Any template can list the terms of a taxonomy
+	for _, name := range site.taxonomies.names {
+		for _, term := range site.taxonomies.taxonomies[name].Terms {
+			fmt.Fprintf(h, "%s %s", name, term.Slug)
+
+			for _, page := range term.Pages {
+				fmt.Fprintf(h, " %s", page.relPath)
+			}
+
+			fmt.Fprintln(h)
+		}
+	}
+
+	for name := range site.funcs {
+		funcs = append(funcs, name)
+	}
+
+	sort.Strings(funcs)
+	fmt.Fprintln(h, strings.Join(funcs, ","))
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// fileHash returns a hash of a file's contents, which is remembered for the
+// rest of the build.
+func (cache *buildCache) fileHash(file string) string {
+	if hash, ok := cache.hashes[file]; ok {
+		return hash
+	}
+
+	hash := "missing"
+
+	if data, err := os.ReadFile(file); err == nil {
+		hash = fmt.Sprintf("%x", sha1.Sum(data))
+	}
+
+	cache.hashes[file] = hash
+	return hash
+}
+
+// pageKey identifies everything that a page's rendered contents depend on.
+func (cache *buildCache) pageKey(site *Site, page *Page) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\n%s\n", cache.Key, cache.fileHash(page.absPath))
+
+	if previous := cache.Pages[page.relPath]; previous != nil {
+		for _, input := range previous.Inputs {
+			fmt.Fprintf(h, "%s %s\n", input, cache.fileHash(input))
+		}
+	}
+
+	var listed []*Page
+
+	if site.listsAllPages(page) {
+		listed = site.listedPages()
+	} else if site.listsPages(page) {
+		listed = site.getPageIndex(page.dir)
+	}
+
+	for _, other := range listed {
+		fmt.Fprintf(h, "%s %s\n", other.relPath, cache.fileHash(other.absPath))
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// restorePages restores the pages that haven't changed since they were
+// cached, so that they can skip rendering.
+func restorePages(site *Site) error {
+	cache := site.cache
+
+	for _, page := range site.Pages() {
+		// Generated pages are cheap to render, and depend on every page.
+		// Paginated pages are always rendered, so that all of their pages
+		// are known.
+		if page.taxonomy != nil || usesFunc(page.template, "paginate") {
+			continue
+		}
+
+		page.cacheKey = cache.pageKey(site, page)
+		previous := cache.Pages[page.relPath]
+
+		if previous == nil || previous.Key != page.cacheKey {
+			continue
+		}
+
+		page.Contents = previous.Contents
+		page.staticInputs = previous.Inputs
+		page.elements = nil
+
+		for _, cached := range previous.Elements {
+			fw, err := getFramework(cached.Framework)
+
+			if err != nil {
+				return err
+			}
+
+			page.elements = append(page.elements, &Element{
+				id:        cached.Id,
+				src:       cached.Src,
+				dir:       cached.Dir,
+				framework: fw,
+				csr:       cached.Csr,
+				ssr:       cached.Ssr,
+				visible:   cached.Visible,
+				idle:      cached.Idle,
+				media:     cached.Media,
+				props:     cached.Props,
+				token:     fmt.Sprintf("<!-- %s -->", cached.Id),
+			})
+		}
+
+		page.cached = true
+	}
+
+	return nil
+}
+
+// recordPages caches every page's contents, once their elements have been
+// rendered.
+func recordPages(site *Site) {
+	cache := site.cache
+
+	for _, page := range site.pages {
+		entry := &cachedPage{
+			Key:        page.cacheKey,
+			Contents:   page.Contents,
+			Inputs:     page.staticInputs,
+			OutputPath: page.OutputPath(),
+		}
+
+		if previous := cache.Pages[page.relPath]; previous != nil {
+			entry.Output = previous.Output
+		}
+
+		for _, element := range page.elements {
+			entry.Elements = append(entry.Elements, &cachedElement{
+				Id:        element.id,
+				Src:       element.src,
+				Dir:       element.dir,
+				Framework: element.framework.name,
+				Csr:       element.csr,
+				Ssr:       element.ssr,
+				Visible:   element.visible,
+				Idle:      element.idle,
+				Media:     element.media,
+				Props:     element.props,
+			})
+		}
+
+		// Pages with props that can't be stored are rendered every time
+		if _, err := json.Marshal(entry); err != nil {
+			delete(cache.Pages, page.relPath)
+			continue
+		}
+
+		cache.Pages[page.relPath] = entry
+	}
+}
+
+// pageChanged reports whether a page's output needs to be written, and
+// remembers its contents for the next build.
+func (cache *buildCache) pageChanged(page *Page) bool {
+	hash := fmt.Sprintf("%x", sha1.Sum([]byte(page.Contents)))
+	entry := cache.Pages[page.relPath]
+
+	if entry == nil {
+		return true
+	}
+
+	changed := entry.Output != hash
+	entry.Output = hash
+
+	if _, err := os.Stat(page.OutputPath()); err != nil {
+		return true
+	}
+
+	return changed
+}
+
+// assetChanged reports whether an asset needs to be copied, and remembers
+// its size and modification time for the next build.
+func (cache *buildCache) assetChanged(site *Site, asset *asset) bool {
+	info, err := os.Stat(asset.absPath)
+
+	if err != nil {
+		return true
+	}
+
+	current := &cachedAsset{Size: info.Size(), ModTime: info.ModTime()}
+	previous := cache.Assets[asset.relPath]
+	cache.Assets[asset.relPath] = current
+
+	if _, err := os.Stat(path.Join(site.outputDir, asset.relPath)); err != nil {
+		return true
+	}
+
+	return previous == nil || previous.Size != current.Size || !previous.ModTime.Equal(current.ModTime)
+}
+
+// removeStale deletes the outputs of pages and assets that no longer exist,
+// and forgets about them.
+func (cache *buildCache) removeStale(site *Site) {
+	pages := map[string]bool{}
+	assets := map[string]bool{}
+
+	for _, page := range site.pages {
+		pages[page.relPath] = true
+	}
+
+	for _, asset := range site.assets {
+		assets[asset.relPath] = true
+	}
+
+	for relPath, entry := range cache.Pages {
+		if !pages[relPath] {
+			os.Remove(entry.OutputPath)
+			delete(cache.Pages, relPath)
+		}
+	}
+
+	for relPath := range cache.Assets {
+		if !assets[relPath] {
+			os.Remove(path.Join(site.outputDir, relPath))
+			delete(cache.Assets, relPath)
+		}
+	}
+}
+
+// readStaticInputs records the files that went into each rendered page's
+// server rendered elements, by following the imports of each element's
+// component through esbuild's metafile.
+func readStaticInputs(site *Site, metafileJson string) error {
+	var meta metafile
+
+	if err := json.Unmarshal([]byte(metafileJson), &meta); err != nil {
+		return err
+	}
+
+	// Components can be rendered without their extension
+	modules := map[string]string{}
+
+	for input := range meta.Inputs {
+		modules[input] = input
+		modules[strings.TrimSuffix(input, path.Ext(input))] = input
+	}
+
+	for _, page := range site.pages {
+		if page.cached {
+			continue
+		}
+
+		inputs := map[string]bool{}
+		var stack []string
+
+		for _, element := range page.elements {
+			if element.ssr {
+				src := strings.TrimPrefix(path.Join(element.dir, element.src), site.inputDir+"/")
+				stack = append(stack, modules[src])
+			}
+		}
+
+		for len(stack) > 0 {
+			end := len(stack) - 1
+			name := stack[end]
+			stack = stack[:end]
+			input, ok := meta.Inputs[name]
+			file := path.Join(site.inputDir, name)
+
+			if !ok || inputs[file] || strings.Contains(name, ":") {
+				continue
+			}
+
+			inputs[file] = true
+
+			for _, imp := range input.Imports {
+				stack = append(stack, imp.Path)
+			}
+		}
+
+		page.staticInputs = nil
+
+		for file := range inputs {
+			page.staticInputs = append(page.staticInputs, file)
+		}
+
+		sort.Strings(page.staticInputs)
+	}
+
+	return nil
+}
+
+func (cache *buildCache) save() error {
+	data, err := json.Marshal(cache)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path.Dir(cache.file), os.ModePerm); err != nil {
+		return err
+	}
+
+	return os.WriteFile(cache.file, data, os.ModePerm)
+}
@@ -0,0 +1,97 @@
+package melange
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestBuildCache(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"pages/index.md":       "{{ count }}{{ range pages }}[{{ .Data.title }}]{{ end }}",
+		"pages/about.md":       "---\ntitle: About\n---\n{{ count }}",
+		"pages/posts/first.md": "---\ntitle: First\n---\n{{ count }}",
+		"pages/image.png":      "png",
+	})
+
+	rendered := 0
+	options := BuildOptions{
+		Dir:   dir,
+		Funcs: template.FuncMap{"count": func() string { rendered++; return "" }},
+	}
+
+	build := func() {
+		rendered = 0
+
+		if _, err := Build(options); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	build()
+
+	if rendered != 3 {
+		t.Fatalf("expected every page to render, got %d", rendered)
+	}
+
+	build()
+
+	if rendered != 0 {
+		t.Fatalf("expected unchanged pages to be restored from the cache, got %d", rendered)
+	}
+
+	// Changing a listed page renders the page that lists it too
+	os.WriteFile(path.Join(dir, "pages/about.md"), []byte("---\ntitle: Changed\n---\n{{ count }}"), os.ModePerm)
+	build()
+
+	if rendered != 2 {
+		t.Fatalf("expected changed page and its index to render, got %d", rendered)
+	}
+
+	html, _ := os.ReadFile(path.Join(dir, "_site/index.html"))
+
+	if !strings.Contains(string(html), "[Changed]") {
+		t.Fatalf("expected index to list the changed page:\n%s", html)
+	}
+
+	// Removed pages and assets are removed from the output
+	os.Remove(path.Join(dir, "pages/posts/first.md"))
+	os.Remove(path.Join(dir, "pages/image.png"))
+	build()
+
+	for _, file := range []string{"_site/posts/first.html", "_site/image.png"} {
+		if _, err := os.Stat(path.Join(dir, file)); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed", file)
+		}
+	}
+}
+
+func TestSitePagesInvalidateCache(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"pages/_theme.html":          `{{ .Page.Contents | safeHTML }}`,
+		"pages/archive.md":           `{{ range .Site.Pages }}[{{ .Title }}]{{ end }}`,
+		"pages/_layouts/recent.html": `{{ range $.Site.Pages }}[{{ .Title }}]{{ end }}`,
+		"pages/recent.md":            "---\nlayout: recent\n---\n",
+		"pages/posts/a.md":           "---\ntitle: A\n---\n",
+	})
+
+	if _, err := Build(BuildOptions{Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	os.WriteFile(path.Join(dir, "pages/posts/a.md"), []byte("---\ntitle: Changed\n---\n"), os.ModePerm)
+
+	if _, err := Build(BuildOptions{Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{"_site/archive.html", "_site/recent.html"} {
+		html, _ := os.ReadFile(path.Join(dir, file))
+
+		if !strings.Contains(string(html), "[Changed]") {
+			t.Fatalf("expected %s to list the changed page:\n%s", file, html)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Islands are tracked on the window, so that bundles that are re-imported by
+// hot module replacement share them with the bundles they replace.
+let state = (window.$melange ||= { islands: {}, pending: new Set() });
+
+// island mounts an element, hydrating its server rendered HTML if it has
+// any. When the element was already mounted, it is only remounted if hot
+// module replacement has marked it as pending.
+export function island(id, ssr, mount) {
+  let el = document.getElementById(id);
+  let prev = state.islands[id];
+
+  if (prev && !state.pending.has(id)) {
+    return;
+  }
+
+  if (prev) {
+    state.pending.delete(id);
+    prev.unmount?.();
+  }
+
+  let unmount = mount(el, ssr && !prev);
+  state.islands[id] = { unmount };
+}
+
+export function onVisible(el, callback) {
+  let observer = new IntersectionObserver(entries => {
+    if (entries.some(entry => entry.isIntersecting)) {
+      observer.disconnect();
+      callback();
+    }
+  });
+
+  observer.observe(el);
+}
+
+export function onIdle(callback) {
+  if ("requestIdleCallback" in window) {
+    requestIdleCallback(callback);
+  } else {
+    setTimeout(callback, 200);
+  }
+}
+
+export function onMedia(query, callback) {
+  let media = matchMedia(query);
+
+  if (media.matches) {
+    callback();
+  } else {
+    media.addEventListener("change", function listener(event) {
+      if (event.matches) {
+        media.removeEventListener("change", listener);
+        callback();
+      }
+    });
+  }
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/danprince/melange"
+)
+
+func main() {
+	var cwd string
+	var serve bool
+	var framework string
+	var workers int
+	var runtime string
+	var addr string
+
+	flag.BoolVar(&serve, "serve", false, "serve the site and rebuild when files change")
+	flag.StringVar(&cwd, "cwd", "", "cwd of your site")
+	flag.StringVar(&framework, "framework", "", "framework for rendering components (preact, react, svelte, solid or vue), overrides melange.yaml")
+	flag.StringVar(&runtime, "runtime", "node", "runtime for rendering components on the server (node, bun, deno or goja)")
+	flag.IntVar(&workers, "workers", 0, "number of runtime processes that render components (defaults to the number of CPUs)")
+	flag.StringVar(&addr, "addr", "", "address to serve the site from (defaults to the port in melange.yaml, or :8000)")
+	flag.Parse()
+
+	if cwd != "" {
+		err := os.Chdir(cwd)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	inputDir, _ := os.Getwd()
+
+	options := melange.BuildOptions{
+		Dir:       inputDir,
+		Framework: framework,
+		Runtime:   runtime,
+		Workers:   workers,
+	}
+
+	if serve {
+		if err := melange.Serve(options, addr); err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	options.Production = true
+	result, err := melange.Build(options)
+
+	if err != nil {
+		printError(err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("built site in %s\n", result.Duration)
+}
+
+func printError(err error) {
+	errs := melange.AsBuildErrors(err)
+
+	if errs == nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+
+		if err.Frame != "" {
+			fmt.Fprintf(os.Stderr, "\n%s\n", err.Frame)
+		}
+
+		if err.Stack != "" {
+			fmt.Fprintf(os.Stderr, "%s\n\n", err.Stack)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package melange
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// collectionFuncs sort, filter and group lists of pages. The list is the
+// last argument, so that they can be chained in pipelines, like
+// {{ range pages | where "draft" false | sortBy "date" "desc" | limit 5 }}.
+var collectionFuncs = template.FuncMap{
+	"sortBy":  sortBy,
+	"where":   where,
+	"groupBy": groupBy,
+	"limit":   limit,
+}
+
+// PageGroup is a list of pages that have the same value for a field.
+type PageGroup struct {
+	Key   string
+	Pages []*Page
+}
+
+// dateLayouts are the formats that dates in front matter can be written in.
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// Date returns the date from the page's front matter, or the zero time if it
+// doesn't have one.
+func (page *Page) Date() time.Time {
+	switch value := page.Data["date"].(type) {
+	case time.Time:
+		return value
+	case string:
+		for _, layout := range dateLayouts {
+			if date, err := time.Parse(layout, value); err == nil {
+				return date
+			}
+		}
+	}
+
+	return time.Time{}
+}
+
+// pageField returns the value of a field of a page, or nil if the page
+// doesn't have it. Fields are read from the front matter, except for a few
+// that melange knows about.
+func pageField(page *Page, field string) any {
+	switch field {
+	case "title":
+		return page.Title
+	case "url":
+		return page.Url
+	case "path":
+		return page.relPath
+	case "name":
+		return page.Name
+	case "date", "year", "month":
+		date := page.Date()
+
+		if date.IsZero() {
+			return nil
+		} else if field == "year" {
+			return date.Year()
+		} else if field == "month" {
+			return int(date.Month())
+		}
+
+		return date
+	}
+
+	return page.Data[field]
+}
+
+// sortBy sorts pages by a field, in ascending order unless the order is
+// "desc". Pages without the field are always last. Pages with the same value
+// keep their order.
+func sortBy(field string, args ...any) ([]*Page, error) {
+	if len(args) == 0 || len(args) > 2 {
+		return nil, fmt.Errorf("sortBy expects a field, an optional order, and a list of pages")
+	}
+
+	pages, ok := args[len(args)-1].([]*Page)
+
+	if !ok {
+		return nil, fmt.Errorf("sortBy expects a list of pages, got %T", args[len(args)-1])
+	}
+
+	desc := false
+
+	if len(args) == 2 {
+		switch order := fmt.Sprint(args[0]); order {
+		case "asc":
+		case "desc":
+			desc = true
+		default:
+			return nil, fmt.Errorf("sortBy order must be \"asc\" or \"desc\", got %q", order)
+		}
+	}
+
+	sorted := append([]*Page{}, pages...)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a := pageField(sorted[i], field)
+		b := pageField(sorted[j], field)
+
+		if a == nil || b == nil {
+			return a != nil
+		}
+
+		if desc {
+			return compareValues(b, a) < 0
+		}
+
+		return compareValues(a, b) < 0
+	})
+
+	return sorted, nil
+}
+
+// compareValues orders dates and numbers by their values, and anything
+// else by its text.
+func compareValues(a any, b any) int {
+	if a, ok := a.(time.Time); ok {
+		if b, ok := b.(time.Time); ok {
+			if a.Before(b) {
+				return -1
+			} else if a.After(b) {
+				return 1
+			}
+
+			return 0
+		}
+	}
+
+	if a, ok := toNumber(a); ok {
+		if b, ok := toNumber(b); ok {
+			if a < b {
+				return -1
+			} else if a > b {
+				return 1
+			}
+
+			return 0
+		}
+	}
+
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func toNumber(value any) (float64, bool) {
+	switch value := value.(type) {
+	case int:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	case uint64:
+		return float64(value), true
+	case float64:
+		return value, true
+	}
+
+	return 0, false
+}
+
+// where filters pages to the ones where a field has a value. A list field
+// matches if any of its items have the value. A missing field matches the
+// zero value, so {{ where "draft" false }} includes pages without "draft".
+func where(field string, value any, pages []*Page) []*Page {
+	matches := []*Page{}
+
+	for _, page := range pages {
+		if fieldMatches(pageField(page, field), value) {
+			matches = append(matches, page)
+		}
+	}
+
+	return matches
+}
+
+func fieldMatches(field any, value any) bool {
+	switch field := field.(type) {
+	case nil:
+		switch value := value.(type) {
+		case nil, bool, string:
+			return value == nil || value == false || value == ""
+		}
+
+		number, ok := toNumber(value)
+		return ok && number == 0
+	case []any:
+		for _, item := range field {
+			if fmt.Sprint(item) == fmt.Sprint(value) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return fmt.Sprint(field) == fmt.Sprint(value)
+}
+
+// groupBy groups pages by the value of a field, in the order that each value
+// first appears. Pages without the field are grouped under an empty key.
+func groupBy(field string, pages []*Page) []*PageGroup {
+	var groups []*PageGroup
+	index := map[string]*PageGroup{}
+
+	for _, page := range pages {
+		key := ""
+
+		if value := pageField(page, field); value != nil {
+			key = fmt.Sprint(value)
+		}
+
+		group := index[key]
+
+		if group == nil {
+			group = &PageGroup{Key: key}
+			index[key] = group
+			groups = append(groups, group)
+		}
+
+		group.Pages = append(group.Pages, page)
+	}
+
+	return groups
+}
+
+// limit returns up to the first n pages.
+func limit(n int, pages []*Page) []*Page {
+	if n < 0 {
+		n = 0
+	}
+
+	if n > len(pages) {
+		n = len(pages)
+	}
+
+	return pages[:n]
+}
+
+// listedPages returns the pages that can be listed, which excludes generated
+// taxonomy pages and the later pages of paginated pages, in the default
+// order, sorted by path.
+func (site *Site) listedPages() []*Page {
+	var pages []*Page
+
+	for _, page := range site.Pages() {
+		if page.taxonomy == nil && page.source == nil {
+			pages = append(pages, page)
+		}
+	}
+
+	return pages
+}
+
+// getPagesIn returns every page beneath a directory, at any depth, except
+// for the directory's own index page. The directory is relative to the pages
+// directory.
+func (site *Site) getPagesIn(dir string) []*Page {
+	dir = path.Join("/", dir)
+	pages := []*Page{}
+
+	for _, page := range site.listedPages() {
+		if page.relPath == path.Join(dir, "index.md") {
+			continue
+		}
+
+		if dir == "/" || strings.HasPrefix(page.relPath, dir+"/") {
+			pages = append(pages, page)
+		}
+	}
+
+	return pages
+}
@@ -0,0 +1,80 @@
+package melange
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestCollectionFuncs(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"pages/_theme.html":         `{{ .Page.Contents | safeHTML }}`,
+		"pages/blog/index.md":       `{{ range pages | where "draft" false | sortBy "date" "desc" | limit 2 }}{{ .Title }} {{ end }}`,
+		"pages/blog/a.md":           "---\ntitle: A\ndate: 2021-05-01\ntags: [go]\n---\n",
+		"pages/blog/b.md":           "---\ntitle: B\ndate: 2022-01-02\n---\n",
+		"pages/blog/c.md":           "---\ntitle: C\ndate: 2022-03-04\ndraft: true\n---\n",
+		"pages/blog/d.md":           "---\ntitle: D\n---\n",
+		"pages/blog/2020/e.md":      "---\ntitle: E\ndate: 2020-12-31T10:00:00Z\ntags: [go, web]\n---\n",
+		"pages/archive.md":          `{{ range pagesIn "/blog" | sortBy "date" | groupBy "year" }}{{ .Key }}:{{ range .Pages }}{{ .Title }}{{ end }} {{ end }}`,
+		"pages/tagged.md":           `{{ range allPages | where "tags" "go" }}{{ .Title }} {{ end }}`,
+		"pages/_layouts/plain.html": `{{ range .Site.Pages | where "draft" true }}{{ .Title }}{{ end }}`,
+		"pages/layout.md":           "---\nlayout: plain\n---\n",
+	})
+
+	if _, err := Build(BuildOptions{Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"_site/blog/index.html": "<p>B A</p>\n",
+		"_site/archive.html":    "<p>2020:E 2021:A 2022:BC :D</p>\n",
+		"_site/tagged.html":     "<p>E A</p>\n",
+		"_site/layout.html":     "C",
+	}
+
+	for file, contents := range expected {
+		html, err := os.ReadFile(path.Join(dir, file))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(html) != contents {
+			t.Fatalf("expected %s to be %q, got %q", file, contents, html)
+		}
+	}
+}
+
+func TestPageIndexOrder(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"pages/_theme.html": `{{ .Page.Contents | safeHTML }}`,
+		"pages/index.md":    `{{ range pages }}{{ .Url }} {{ end }}`,
+		"pages/c.md":        "",
+		"pages/a.md":        "",
+		"pages/b/index.md":  "",
+		"pages/b/nested.md": "",
+	})
+
+	for i := 0; i < 5; i++ {
+		if _, err := Build(BuildOptions{Dir: dir}); err != nil {
+			t.Fatal(err)
+		}
+
+		html, _ := os.ReadFile(path.Join(dir, "_site/index.html"))
+		expected := "<p>/a.html /b/ /c.html</p>\n"
+
+		if string(html) != expected {
+			t.Fatalf("expected %q, got %q", expected, html)
+		}
+	}
+}
+
+func TestSortByErrors(t *testing.T) {
+	if _, err := sortBy("date", "sideways", []*Page{}); err == nil {
+		t.Fatal("expected an error for an unknown order")
+	}
+
+	if _, err := sortBy("date", "desc"); err == nil {
+		t.Fatal("expected an error without a list of pages")
+	}
+}
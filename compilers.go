@@ -0,0 +1,154 @@
+package melange
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+//go:embed compilers.js
+var compilersJs string
+
+// compileComponent compiles a component with a framework's compiler. It's a
+// variable so that tests can replace it.
+var compileComponent = compileWithNode
+
+// compileWithNode compiles a component with a framework's own compiler, using
+// the version that is installed in the site's node_modules.
+func compileWithNode(site *Site, compiler string, filename string, ssr bool) (string, error) {
+	mode := "dom"
+
+	if ssr {
+		mode = "ssr"
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("node", "-e", compilersJs, compiler, mode, filename)
+	cmd.Dir = site.inputDir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if stderr.Len() > 0 {
+			return "", fmt.Errorf("%s compiler failed: %s", compiler, strings.TrimSpace(stderr.String()))
+		}
+
+		return "", fmt.Errorf("%s compiler failed: %s", compiler, err)
+	}
+
+	return stdout.String(), nil
+}
+
+// compilerPlugin compiles any file matching filter with a framework's own
+// compiler.
+func compilerPlugin(site *Site, compiler string, filter string, loader api.Loader, ssr bool) api.Plugin {
+	return api.Plugin{
+		Name: fmt.Sprintf("compile-%s", compiler),
+		Setup: func(build api.PluginBuild) {
+			build.OnLoad(api.OnLoadOptions{
+				Filter: filter,
+			}, func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+				contents, err := compileComponent(site, compiler, args.Path, ssr)
+
+				if err != nil {
+					return api.OnLoadResult{}, err
+				}
+
+				return api.OnLoadResult{
+					Contents:   &contents,
+					Loader:     loader,
+					ResolveDir: path.Dir(args.Path),
+				}, nil
+			})
+		},
+	}
+}
+
+var jsxFilter = regexp.MustCompile(`\.[jt]sx$`)
+
+// scriptFilter matches the local modules that pass the Solid tag on to their
+// imports, so that components re-exported from plain modules are compiled.
+var scriptFilter = regexp.MustCompile(`\.[jt]sx?$`)
+
+// solidPlugin compiles the JSX in Solid components. Solid shares file
+// extensions with the other JSX frameworks, so only modules that were
+// imported by Solid elements (and their local dependencies) are compiled.
+// The virtual framework modules tag their imports with the framework's
+// name, and the tag is passed down through each local script, including
+// plain .js and .ts modules that don't need compiling themselves.
+func solidPlugin(site *Site, ssr bool) api.Plugin {
+	tag := "solid"
+
+	return api.Plugin{
+		Name: "compile-solid",
+		Setup: func(build api.PluginBuild) {
+			build.OnResolve(api.OnResolveOptions{
+				Filter: ".*",
+			}, func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+				isLocal := strings.HasPrefix(args.Path, ".") || path.IsAbs(args.Path)
+
+				if args.PluginData != tag || !isLocal {
+					return api.OnResolveResult{}, nil
+				}
+
+				result := build.Resolve(args.Path, api.ResolveOptions{
+					Importer:   args.Importer,
+					ResolveDir: args.ResolveDir,
+					Kind:       args.Kind,
+				})
+
+				if len(result.Errors) > 0 || !scriptFilter.MatchString(result.Path) {
+					return api.OnResolveResult{}, nil
+				}
+
+				return api.OnResolveResult{
+					Path:       result.Path,
+					Namespace:  result.Namespace,
+					PluginData: tag,
+				}, nil
+			})
+
+			build.OnLoad(api.OnLoadOptions{
+				Filter: scriptFilter.String(),
+			}, func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+				if args.PluginData != tag {
+					return api.OnLoadResult{}, nil
+				}
+
+				var contents string
+				var err error
+				loader := api.LoaderJS
+
+				if jsxFilter.MatchString(args.Path) {
+					contents, err = compileComponent(site, "solid", args.Path, ssr)
+				} else {
+					var source []byte
+					source, err = os.ReadFile(args.Path)
+					contents = string(source)
+
+					if path.Ext(args.Path) == ".ts" {
+						loader = api.LoaderTS
+					}
+				}
+
+				if err != nil {
+					return api.OnLoadResult{}, err
+				}
+
+				return api.OnLoadResult{
+					Contents:   &contents,
+					Loader:     loader,
+					ResolveDir: path.Dir(args.Path),
+					PluginData: tag,
+				}, nil
+			})
+		},
+	}
+}
@@ -0,0 +1,91 @@
+// Compiles framework specific component formats with the compilers that are
+// installed in the site's node_modules. Usage:
+//
+//   node -e <script> <compiler> <ssr|dom> <filename>
+//
+// The compiled JavaScript is written to stdout.
+
+let fs = require("fs");
+let path = require("path");
+
+let [compiler, mode, filename] = process.argv.slice(1);
+let source = fs.readFileSync(filename, "utf-8");
+let ssr = mode === "ssr";
+
+let compilers = {
+  svelte() {
+    let svelte = require("svelte/compiler");
+    let major = parseInt(svelte.VERSION);
+    let { js } = svelte.compile(source, {
+      filename,
+      generate: ssr ? "ssr" : "dom",
+      hydratable: true,
+      format: "esm",
+      css: major >= 4 ? "injected" : true,
+    });
+    return js.code;
+  },
+
+  async solid() {
+    let babel = require("@babel/core");
+    let presets = [[require.resolve("babel-preset-solid"), { generate: ssr ? "ssr" : "dom", hydratable: true }]];
+
+    if (/\.tsx?$/.test(filename)) {
+      presets.push([require.resolve("@babel/preset-typescript"), { isTSX: true, allExtensions: true }]);
+    }
+
+    let { code } = await babel.transformAsync(source, { filename, presets, babelrc: false, configFile: false });
+    return code;
+  },
+
+  vue() {
+    let sfc = require("@vue/compiler-sfc");
+    let { descriptor, errors } = sfc.parse(source, { filename });
+
+    if (errors.length) {
+      throw errors[0];
+    }
+
+    let id = path.basename(filename).replace(/\W/g, "_");
+    let code = "const __sfc__ = {};\n";
+
+    if (descriptor.script || descriptor.scriptSetup) {
+      let script = sfc.compileScript(descriptor, {
+        id,
+        inlineTemplate: true,
+        templateOptions: { ssr },
+      });
+      code = sfc.rewriteDefault(script.content, "__sfc__");
+    }
+
+    if (descriptor.template && !descriptor.scriptSetup) {
+      let name = ssr ? "ssrRender" : "render";
+      let template = sfc.compileTemplate({
+        id,
+        filename,
+        ssr,
+        source: descriptor.template.content,
+      });
+      code += "\n" + template.code.replace(`export function ${name}`, `function ${name}`);
+      code += `\n__sfc__.${name} = ${name};`;
+    }
+
+    if (!ssr) {
+      for (let style of descriptor.styles) {
+        let { code: css } = sfc.compileStyle({ id, filename, source: style.content });
+        code += `\ndocument.head.appendChild(document.createElement("style")).textContent = ${JSON.stringify(css)};`;
+      }
+    }
+
+    code += "\nexport default __sfc__;";
+    return code;
+  },
+};
+
+Promise.resolve()
+  .then(() => compilers[compiler]())
+  .then(code => process.stdout.write(code))
+  .catch(err => {
+    process.stderr.write(String(err && err.message || err));
+    process.exit(1);
+  });
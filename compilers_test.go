@@ -0,0 +1,135 @@
+package melange
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// stubCompiler replaces the compiler runner for the rest of the test.
+func stubCompiler(t *testing.T, compile func(site *Site, compiler string, filename string, ssr bool) (string, error)) {
+	original := compileComponent
+	compileComponent = compile
+	t.Cleanup(func() { compileComponent = original })
+}
+
+// taggedEntryPlugin loads a virtual entry point whose imports are tagged, like
+// the modules that melange generates for each framework.
+func taggedEntryPlugin(dir string, contents string, tag string) api.Plugin {
+	return api.Plugin{
+		Name: "tagged-entry",
+		Setup: func(build api.PluginBuild) {
+			build.OnResolve(api.OnResolveOptions{
+				Filter: "^entry$",
+			}, func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+				return api.OnResolveResult{Path: args.Path, Namespace: "entry"}, nil
+			})
+
+			build.OnLoad(api.OnLoadOptions{
+				Filter:    ".*",
+				Namespace: "entry",
+			}, func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+				return api.OnLoadResult{
+					Contents:   &contents,
+					Loader:     api.LoaderJS,
+					ResolveDir: dir,
+					PluginData: tag,
+				}, nil
+			})
+		},
+	}
+}
+
+func TestSolidPluginOnlyCompilesSolidModules(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"counter.jsx":                   `import "./nested.tsx"; import "./util.js"; import "./types.ts"; import "pkg";`,
+		"nested.tsx":                    `export default 1;`,
+		"util.js":                       `import "./helper.jsx";`,
+		"helper.jsx":                    `export default 2;`,
+		"types.ts":                      `export * from "./typed.tsx"; export const n: number = 5;`,
+		"typed.tsx":                     `export default 6;`,
+		"other.jsx":                     `import "./untagged.jsx";`,
+		"untagged.jsx":                  `export default 3;`,
+		"node_modules/pkg/index.jsx":    `export default 4;`,
+		"node_modules/pkg/package.json": `{"main": "index.jsx"}`,
+	})
+
+	var compiled []string
+	var mu sync.Mutex
+
+	stubCompiler(t, func(site *Site, compiler string, filename string, ssr bool) (string, error) {
+		if compiler != "solid" {
+			t.Fatalf("expected the solid compiler, got %s", compiler)
+		}
+
+		mu.Lock()
+		compiled = append(compiled, strings.TrimPrefix(filename, dir))
+		mu.Unlock()
+		source, err := os.ReadFile(filename)
+		return string(source), err
+	})
+
+	site := &Site{inputDir: dir}
+
+	result := api.Build(api.BuildOptions{
+		EntryPoints:   []string{"entry", "./other.jsx"},
+		AbsWorkingDir: dir,
+		Bundle:        true,
+		Outdir:        "out",
+		Plugins: []api.Plugin{
+			taggedEntryPlugin(dir, `import "./counter.jsx";`, "solid"),
+			solidPlugin(site, false),
+		},
+	})
+
+	if len(result.Errors) > 0 {
+		t.Fatalf("expected build to succeed, got %v", result.Errors)
+	}
+
+	sort.Strings(compiled)
+	expected := []string{"/counter.jsx", "/helper.jsx", "/nested.tsx", "/typed.tsx"}
+
+	if fmt.Sprint(compiled) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v to be compiled, got %v", expected, compiled)
+	}
+}
+
+func TestCompilerPluginErrors(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"app.js":         `import "./counter.svelte";`,
+		"counter.svelte": `<p>{count</p>`,
+	})
+
+	stubCompiler(t, func(site *Site, compiler string, filename string, ssr bool) (string, error) {
+		return "", fmt.Errorf("%s compiler failed: unexpected end of input", compiler)
+	})
+
+	site := &Site{inputDir: dir}
+
+	result := api.Build(api.BuildOptions{
+		EntryPoints:   []string{"./app.js"},
+		AbsWorkingDir: dir,
+		Bundle:        true,
+		Plugins:       []api.Plugin{compilerPlugin(site, "svelte", `\.svelte$`, api.LoaderJS, false)},
+	})
+
+	if len(result.Errors) != 1 || result.Errors[0].PluginName != "compile-svelte" {
+		t.Fatalf("expected 1 error from compile-svelte, got %v", result.Errors)
+	}
+
+	err := newBundlerErrors(site, result.Errors)[0]
+
+	if err.Message != "svelte compiler failed: unexpected end of input" {
+		t.Fatalf("expected the compiler's error, got %q", err.Message)
+	}
+
+	if err.File != path.Join(dir, "app.js") || err.Line != 1 || err.Column != 8 {
+		t.Fatalf("expected the error to point at the import in app.js, got %s:%d:%d", err.File, err.Line, err.Column)
+	}
+}
@@ -0,0 +1,245 @@
+package melange
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"gopkg.in/yaml.v2"
+)
+
+// configFiles are the names that a site's config can have, in the order
+// they're looked for.
+var configFiles = []string{"melange.yaml", "melange.yml", "melange.json"}
+
+// siteConfig is the configuration in a site's melange.yaml or melange.json.
+// Directories are relative to the site's root, except for assetsDir, which
+// is relative to the output directory. Taxonomies are the front matter keys
+// that pages are grouped by.
+type siteConfig struct {
+	Title      string         `yaml:"title"`
+	BaseURL    string         `yaml:"baseURL"`
+	Author     string         `yaml:"author"`
+	OutputDir  string         `yaml:"outputDir"`
+	PagesDir   string         `yaml:"pagesDir"`
+	AssetsDir  string         `yaml:"assetsDir"`
+	Framework  string         `yaml:"framework"`
+	Port       int            `yaml:"port"`
+	Markdown   markdownConfig `yaml:"markdown"`
+	Taxonomies []string       `yaml:"taxonomies"`
+
+	// file is the path of the config file, which is empty when the site
+	// doesn't have one.
+	file string
+}
+
+type markdownConfig struct {
+	Extensions []string `yaml:"extensions"`
+}
+
+func defaultConfig() *siteConfig {
+	return &siteConfig{
+		OutputDir:  "_site",
+		PagesDir:   "pages",
+		AssetsDir:  "_assets",
+		Port:       8000,
+		Markdown:   markdownConfig{Extensions: []string{"gfm", "footnote"}},
+		Taxonomies: []string{"tags"},
+	}
+}
+
+// markdownExtensions are the goldmark extensions that can be enabled with
+// the "markdown.extensions" key.
+var markdownExtensions = map[string]goldmark.Extender{
+	"gfm":            extension.GFM,
+	"table":          extension.Table,
+	"strikethrough":  extension.Strikethrough,
+	"linkify":        extension.Linkify,
+	"tasklist":       extension.TaskList,
+	"footnote":       extension.Footnote,
+	"definitionlist": extension.DefinitionList,
+	"typographer":    extension.Typographer,
+}
+
+// loadConfig reads the config file in a site's root directory. Sites without
+// one use the default config.
+func loadConfig(inputDir string) (*siteConfig, error) {
+	config := defaultConfig()
+
+	for _, name := range configFiles {
+		file := path.Join(inputDir, name)
+		contents, err := os.ReadFile(file)
+
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		config.file = file
+
+		if err := parseConfig(config, contents); err != nil {
+			return nil, newConfigError(file, contents, err)
+		}
+
+		if err := validateConfig(config); err != nil {
+			return nil, newConfigError(file, contents, err)
+		}
+
+		break
+	}
+
+	return config, nil
+}
+
+// parseConfig decodes a config file strictly, so that misspelled keys are
+// reported rather than ignored. JSON is a subset of YAML, so both are
+// decoded the same way once the JSON has been checked.
+func parseConfig(config *siteConfig, contents []byte) error {
+	if path.Ext(config.file) == ".json" {
+		var value any
+
+		if err := json.Unmarshal(contents, &value); err != nil {
+			return err
+		}
+	}
+
+	return yaml.UnmarshalStrict(contents, config)
+}
+
+// configKeyError is a problem with the value of a key in the config file.
+type configKeyError struct {
+	key     string
+	message string
+}
+
+func (e *configKeyError) Error() string {
+	return e.message
+}
+
+func validateConfig(config *siteConfig) error {
+	if config.Framework != "" {
+		if _, err := getFramework(config.Framework); err != nil {
+			return &configKeyError{"framework", fmt.Sprintf("%s, expected one of %s", err, strings.Join(frameworkNames(), ", "))}
+		}
+	}
+
+	for _, name := range config.Markdown.Extensions {
+		if _, ok := markdownExtensions[name]; !ok {
+			var names []string
+
+			for name := range markdownExtensions {
+				names = append(names, name)
+			}
+
+			sort.Strings(names)
+			return &configKeyError{"extensions", fmt.Sprintf("unknown markdown extension %q, expected one of %s", name, strings.Join(names, ", "))}
+		}
+	}
+
+	for _, name := range config.Taxonomies {
+		if !taxonomyNameRegex.MatchString(name) {
+			return &configKeyError{"taxonomies", fmt.Sprintf("taxonomy names can only contain letters, numbers, - and _, got %q", name)}
+		}
+	}
+
+	if config.Port < 1 || config.Port > 65535 {
+		return &configKeyError{"port", fmt.Sprintf("port must be between 1 and 65535, got %d", config.Port)}
+	}
+
+	for key, dir := range map[string]string{"outputDir": config.OutputDir, "pagesDir": config.PagesDir} {
+		if dir == "" {
+			return &configKeyError{key, fmt.Sprintf("%s can't be empty", key)}
+		}
+	}
+
+	// The assets directory is removed before production builds, so it can't
+	// be the output directory itself.
+	if assetsDir := path.Clean(config.AssetsDir); path.IsAbs(assetsDir) || assetsDir == "." || strings.HasPrefix(assetsDir, "..") {
+		return &configKeyError{"assetsDir", fmt.Sprintf("assetsDir must be a directory inside outputDir, got %q", config.AssetsDir)}
+	}
+
+	return nil
+}
+
+var taxonomyNameRegex = regexp.MustCompile(`^[\w-]+$`)
+var configFieldRegex = regexp.MustCompile(`^line (\d+): field (\S+) not found in type (\S+)$`)
+var configLineRegex = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// newConfigError converts an error from parsing or validating a config file
+// into a build error, with the line that it came from when it's known.
+func newConfigError(file string, contents []byte, err error) *BuildError {
+	buildErr := &BuildError{Category: ConfigError, File: file, Message: err.Error()}
+
+	var jsonErr *json.SyntaxError
+	var yamlErr *yaml.TypeError
+	var keyErr *configKeyError
+
+	if errors.As(err, &jsonErr) {
+		before := contents[:jsonErr.Offset]
+		buildErr.Line = bytes.Count(before, []byte("\n")) + 1
+		buildErr.Column = len(before) - bytes.LastIndexByte(before, '\n') - 1
+	} else if errors.As(err, &yamlErr) && len(yamlErr.Errors) > 0 {
+		// Only the first problem is reported, the rest are usually similar
+		if match := configFieldRegex.FindStringSubmatch(yamlErr.Errors[0]); match != nil {
+			buildErr.Line, _ = strconv.Atoi(match[1])
+			buildErr.Message = fmt.Sprintf("unknown key %q, expected one of %s", match[2], strings.Join(configKeys(match[3]), ", "))
+		} else if match := configLineRegex.FindStringSubmatch(yamlErr.Errors[0]); match != nil {
+			buildErr.Line, _ = strconv.Atoi(match[1])
+			buildErr.Message = match[2]
+		}
+	} else if errors.As(err, &keyErr) {
+		buildErr.Line = configKeyLine(contents, keyErr.key)
+	} else if match := yamlLineRegex.FindStringSubmatch(err.Error()); match != nil {
+		buildErr.Line, _ = strconv.Atoi(match[1])
+		buildErr.Message = match[2]
+	}
+
+	buildErr.Frame = codeFrame(file, buildErr.Line, buildErr.Column)
+	return buildErr
+}
+
+// configKeys returns the keys that are allowed in one of the config types,
+// given its name as it appears in YAML errors.
+func configKeys(typeName string) []string {
+	var keys []string
+
+	for _, value := range []any{siteConfig{}, markdownConfig{}} {
+		t := reflect.TypeOf(value)
+
+		if t.String() != typeName {
+			continue
+		}
+
+		for i := 0; i < t.NumField(); i++ {
+			if key := t.Field(i).Tag.Get("yaml"); key != "" {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	return keys
+}
+
+// configKeyLine finds the line that sets a key in a config file.
+func configKeyLine(contents []byte, key string) int {
+	regex := regexp.MustCompile(`^\s*"?` + regexp.QuoteMeta(key) + `"?\s*:`)
+
+	for i, line := range strings.Split(string(contents), "\n") {
+		if regex.MatchString(line) {
+			return i + 1
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,84 @@
+package melange
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestConfig(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"melange.yaml":        "title: Blog\nauthor: Dan\nbaseURL: https://example.com\noutputDir: public\npagesDir: content\nmarkdown:\n  extensions: [table]\n",
+		"content/_theme.html": `<title>{{ .Site.Title }} by {{ .Site.Author }}</title><base href="{{ .Site.BaseURL }}">{{ .Page.Contents | safeHTML }}`,
+		"content/index.md":    "~~old~~",
+		"pages/ignored.md":    "ignored",
+	})
+
+	if _, err := Build(BuildOptions{Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	html, _ := os.ReadFile(path.Join(dir, "public/index.html"))
+	expected := "<title>Blog by Dan</title><base href=\"https://example.com\"><p>~~old~~</p>\n"
+
+	if string(html) != expected {
+		t.Fatalf("expected %q, got %q", expected, html)
+	}
+
+	if _, err := os.Stat(path.Join(dir, "_site")); err == nil {
+		t.Fatal("expected the default output directory not to be used")
+	}
+}
+
+func TestConfigJSON(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"melange.json": `{"title": "Docs", "port": 3000}`,
+	})
+
+	config, err := loadConfig(dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Title != "Docs" || config.Port != 3000 || config.OutputDir != "_site" {
+		t.Fatalf("unexpected config %+v", config)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	tests := []struct {
+		file     string
+		contents string
+		line     int
+		message  string
+	}{
+		{"melange.yaml", "title: Blog\ntitel: Blog\n", 2, `unknown key "titel", expected one of title, baseURL`},
+		{"melange.yaml", "markdown:\n  extension: [gfm]\n", 2, `unknown key "extension", expected one of extensions`},
+		{"melange.json", "{\n  \"title\": \"Blog\",\n  \"prot\": 3000\n}", 3, `unknown key "prot"`},
+		{"melange.json", "{\n  \"title\": \n}", 3, "invalid character"},
+		{"melange.yaml", "port: abc\n", 1, "cannot unmarshal"},
+		{"melange.yaml", "title: Blog\nframework: angular\n", 2, `unknown framework "angular", expected one of preact, react`},
+		{"melange.yaml", "markdown:\n  extensions: [gfm, emoji]\n", 2, `unknown markdown extension "emoji"`},
+		{"melange.yaml", "assetsDir: .\n", 1, "assetsDir must be a directory inside outputDir"},
+	}
+
+	for _, test := range tests {
+		dir := createTestSite(t, map[string]string{
+			test.file:        test.contents,
+			"pages/index.md": "",
+		})
+
+		_, err := Build(BuildOptions{Dir: dir})
+		errs := AsBuildErrors(err)
+
+		if len(errs) != 1 || errs[0].Category != ConfigError || errs[0].File != path.Join(dir, test.file) {
+			t.Fatalf("expected config error in %s, got %v", test.file, err)
+		}
+
+		if errs[0].Line != test.line || !strings.Contains(errs[0].Message, test.message) {
+			t.Fatalf("expected error on line %d containing %q, got %d: %q", test.line, test.message, errs[0].Line, errs[0].Message)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package melange
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// dataLoaders parse each of the formats that data files can be written in.
+var dataLoaders = map[string]func(contents []byte) (any, error){
+	".json": loadJsonData,
+	".yaml": loadYamlData,
+	".yml":  loadYamlData,
+	".toml": loadTomlData,
+	".csv":  loadCsvData,
+}
+
+// loadData loads the files in the "_data" directory, so that templates can
+// use them as .Site.Data.<name>. Files in subdirectories are nested, so
+// "_data/team/members.yaml" becomes .Site.Data.team.members.
+func loadData(site *Site) error {
+	site.data = map[string]any{}
+	site.dataFiles = nil
+	dir := path.Join(site.pagesDir, "_data")
+
+	return filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return fs.SkipDir
+		} else if err != nil {
+			return err
+		}
+
+		ext := path.Ext(file)
+		load, ok := dataLoaders[ext]
+
+		if entry.IsDir() || !ok || shouldIgnore(entry.Name()) {
+			return nil
+		}
+
+		contents, err := os.ReadFile(file)
+
+		if err != nil {
+			return err
+		}
+
+		value, err := load(contents)
+
+		if err != nil {
+			return newDataError(file, contents, err)
+		}
+
+		// Walk down to the map for the file's directory
+		data := site.data
+		parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(file, dir+"/"), ext), "/")
+
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := data[part].(map[string]any)
+
+			if !ok {
+				child = map[string]any{}
+				data[part] = child
+			}
+
+			data = child
+		}
+
+		data[parts[len(parts)-1]] = value
+		site.dataFiles = append(site.dataFiles, file)
+		return nil
+	})
+}
+
+func loadJsonData(contents []byte) (any, error) {
+	var value any
+	err := json.Unmarshal(contents, &value)
+	return value, err
+}
+
+func loadYamlData(contents []byte) (any, error) {
+	var value any
+	err := yaml.Unmarshal(contents, &value)
+	return normalizeYaml(value), err
+}
+
+func loadTomlData(contents []byte) (any, error) {
+	value := map[string]any{}
+	_, err := toml.Decode(string(contents), &value)
+	return value, err
+}
+
+// loadCsvData loads a CSV file as a list of rows, keyed by the names in the
+// header row.
+func loadCsvData(contents []byte) (any, error) {
+	records, err := csv.NewReader(bytes.NewReader(contents)).ReadAll()
+
+	if err != nil || len(records) == 0 {
+		return []map[string]string{}, err
+	}
+
+	header := records[0]
+	rows := []map[string]string{}
+
+	for _, record := range records[1:] {
+		row := map[string]string{}
+
+		for i, name := range header {
+			row[name] = record[i]
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// normalizeYaml converts the map[interface{}]interface{} values that YAML
+// produces into map[string]any, so that they can be serialized as JSON when
+// they're passed as props.
+func normalizeYaml(value any) any {
+	switch value := value.(type) {
+	case map[any]any:
+		m := map[string]any{}
+
+		for k, v := range value {
+			m[fmt.Sprint(k)] = normalizeYaml(v)
+		}
+
+		return m
+	case map[string]any:
+		for k, v := range value {
+			value[k] = normalizeYaml(v)
+		}
+
+		return value
+	case []any:
+		for i, v := range value {
+			value[i] = normalizeYaml(v)
+		}
+
+		return value
+	default:
+		return value
+	}
+}
+
+// newDataError reports the position of an error in a data file, when the
+// parser provides one.
+func newDataError(file string, contents []byte, err error) *BuildError {
+	buildErr := &BuildError{Category: DataError, File: file, Message: err.Error()}
+
+	var jsonErr *json.SyntaxError
+	var tomlErr toml.ParseError
+	var csvErr *csv.ParseError
+
+	if errors.As(err, &jsonErr) {
+		before := contents[:jsonErr.Offset]
+		buildErr.Line = bytes.Count(before, []byte("\n")) + 1
+		buildErr.Column = len(before) - bytes.LastIndexByte(before, '\n') - 1
+	} else if errors.As(err, &tomlErr) {
+		buildErr.Line = tomlErr.Position.Line
+	} else if errors.As(err, &csvErr) {
+		buildErr.Line = csvErr.Line
+		buildErr.Column = csvErr.Column
+	} else if match := yamlLineRegex.FindStringSubmatch(err.Error()); match != nil {
+		buildErr.Line, _ = strconv.Atoi(match[1])
+		buildErr.Message = match[2]
+	}
+
+	buildErr.Frame = codeFrame(file, buildErr.Line, buildErr.Column)
+	return buildErr
+}
@@ -0,0 +1,75 @@
+package melange
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestData(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"pages/_data/site.json":         `{"name": "Melange"}`,
+		"pages/_data/nav.yaml":          "- title: Home\n  url: /\n",
+		"pages/_data/team/members.toml": "[[member]]\nname = \"Dan\"\n",
+		"pages/_data/prices.csv":        "item,price\ntea,2\n",
+		"pages/_theme.html":             `<title>{{ .Site.Data.site.name }}</title>{{ .Page.Contents | safeHTML }}`,
+		"pages/index.md":                `{{ range .Site.Data.nav }}{{ .title }} {{ end }}{{ (index .Site.Data.team.members.member 0).name }} {{ (index .Site.Data.prices 0).price }}`,
+	})
+
+	if _, err := Build(BuildOptions{Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	html, _ := os.ReadFile(path.Join(dir, "_site/index.html"))
+	expected := "<title>Melange</title><p>Home Dan 2</p>\n"
+
+	if string(html) != expected {
+		t.Fatalf("expected %q, got %q", expected, html)
+	}
+}
+
+func TestDataProps(t *testing.T) {
+	value, err := loadYamlData([]byte("links:\n  - title: Home\n    url: /\n"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := props{"nav": value}
+	expected := `{"nav":{"links":[{"title":"Home","url":"/"}]}}`
+
+	if json := toJson(&p); json != expected {
+		t.Fatalf("expected %s, got %s", expected, json)
+	}
+}
+
+func TestDataErrors(t *testing.T) {
+	tests := []struct {
+		file     string
+		contents string
+		line     int
+	}{
+		{"pages/_data/site.json", "{\n  \"name\": \n}", 3},
+		{"pages/_data/site.yaml", "name: a\n  b: c\n", 2},
+		{"pages/_data/site.toml", "name = \"a\"\nname = \"b\"\n", 2},
+		{"pages/_data/site.csv", "a,b\n1,2\n3\n", 3},
+	}
+
+	for _, test := range tests {
+		dir := createTestSite(t, map[string]string{
+			test.file:        test.contents,
+			"pages/index.md": "",
+		})
+
+		_, err := Build(BuildOptions{Dir: dir})
+		errs := AsBuildErrors(err)
+
+		if len(errs) != 1 || errs[0].Category != DataError || errs[0].File != path.Join(dir, test.file) {
+			t.Fatalf("expected data error in %s, got %v", test.file, err)
+		}
+
+		if errs[0].Line != test.line {
+			t.Fatalf("expected error on line %d of %s, got %d", test.line, test.file, errs[0].Line)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+let [sockAddr] = Deno.args;
+let conn = await Deno.connect({ transport: "unix", path: sockAddr });
+let writer = conn.writable.getWriter();
+let encoder = new TextEncoder();
+let decoder = new TextDecoder();
+
+// Bundles are imported by URL, so stack traces are rewritten to use the
+// bundle's path instead, which is what the source maps are keyed by.
+function serializeError(err, url, path) {
+  if (err instanceof Error) {
+    return { message: err.message, stack: (err.stack || "").replaceAll(url, path) };
+  } else {
+    return { message: String(err), stack: "" };
+  }
+}
+
+// Each element is rendered separately, so that one broken component can't
+// stop the others from rendering.
+async function renderElements(path) {
+  let response = { html: {}, errors: {} };
+  // Deno never evicts modules, so melange restarts the host instead of
+  // asking it to import the same bundle twice
+  let url = `file://${path}`;
+
+  try {
+    let { default: elements } = await import(url);
+
+    await Promise.all(
+      Object.entries(elements).map(async ([id, render]) => {
+        try {
+          response.html[id] = await render();
+        } catch (err) {
+          response.errors[id] = serializeError(err, url, path);
+        }
+      })
+    );
+  } catch (err) {
+    response.error = serializeError(err, url, path);
+  }
+
+  return response;
+}
+
+// Messages are framed as a 4 byte big-endian length followed by JSON.
+function send(message) {
+  let body = encoder.encode(JSON.stringify(message));
+  let frame = new Uint8Array(4 + body.length);
+  new DataView(frame.buffer).setUint32(0, body.length);
+  frame.set(body, 4);
+  writer.write(frame);
+}
+
+let buffer = new Uint8Array(0);
+
+for await (let chunk of conn.readable) {
+  let next = new Uint8Array(buffer.length + chunk.length);
+  next.set(buffer);
+  next.set(chunk, buffer.length);
+  buffer = next;
+
+  while (buffer.length >= 4) {
+    let length = new DataView(buffer.buffer, buffer.byteOffset).getUint32(0);
+
+    if (buffer.length < 4 + length) {
+      break;
+    }
+
+    let request = JSON.parse(decoder.decode(buffer.subarray(4, 4 + length)));
+    buffer = buffer.subarray(4 + length);
+    renderElements(request.path).then(result => send({ id: request.id, result }));
+  }
+}
+
+// Exit with the process that started us
+Deno.exit(0);
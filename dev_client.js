@@ -0,0 +1,21 @@
+let events = new EventSource("/_melange/events");
+
+events.addEventListener("reload", () => {
+  location.reload();
+});
+
+// Hot module replacement for islands. The updated bundle is re-imported and
+// the runtime remounts the elements that are marked as pending.
+events.addEventListener("update", event => {
+  let updates = JSON.parse(event.data);
+
+  for (let { src, ids } of updates) {
+    if (document.querySelector(`script[src="${src}"]`) && window.$melange) {
+      for (let id of ids) {
+        window.$melange.pending.add(id);
+      }
+
+      import(`${src}?t=${Date.now()}`);
+    }
+  }
+});
@@ -0,0 +1,264 @@
+package melange
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// ErrorCategory describes the stage of the build that an error came from.
+type ErrorCategory string
+
+const (
+	TemplateParseError ErrorCategory = "template parse"
+	TemplateExecError  ErrorCategory = "template exec"
+	MarkdownError      ErrorCategory = "markdown"
+	BundlerError       ErrorCategory = "esbuild"
+	RuntimeError       ErrorCategory = "js runtime"
+	PreflightError     ErrorCategory = "preflight"
+	DataError          ErrorCategory = "data"
+	ConfigError        ErrorCategory = "config"
+)
+
+// BuildError is an error that can be traced back to a file in the site.
+// Line and Column are 1-based, and are zero when they are unknown.
+type BuildError struct {
+	Category ErrorCategory
+	File     string
+	Line     int
+	Column   int
+	Message  string
+	// Frame is an excerpt of the source around the error.
+	Frame string
+	// Stack is the source mapped stack trace for runtime errors.
+	Stack string
+}
+
+func (e *BuildError) Error() string {
+	location := e.File
+
+	if e.Line > 0 {
+		location = fmt.Sprintf("%s:%d", location, e.Line)
+	}
+
+	if e.Column > 0 {
+		location = fmt.Sprintf("%s:%d", location, e.Column)
+	}
+
+	if location == "" {
+		return fmt.Sprintf("%s error: %s", e.Category, e.Message)
+	}
+
+	return fmt.Sprintf("%s: %s error: %s", location, e.Category, e.Message)
+}
+
+// BuildErrors is returned when a stage of the build fails with more than
+// one error.
+type BuildErrors []*BuildError
+
+func (errs BuildErrors) Error() string {
+	var lines []string
+
+	for _, err := range errs {
+		lines = append(lines, err.Error())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Text and HTML templates report errors as "template: name:line: message"
+// or "template: name:line:col: message", and HTML templates report escaping
+// errors as "html/template:name:line:col: message".
+var templateErrorRegex = regexp.MustCompile(`^(?:html/)?template: ?[^:]*:(\d+):(?:(\d+):)? (.*)$`)
+
+func newTemplateError(category ErrorCategory, file string, err error) *BuildError {
+	buildErr := &BuildError{Category: category, File: file, Message: err.Error()}
+
+	if match := templateErrorRegex.FindStringSubmatch(err.Error()); match != nil {
+		buildErr.Line, _ = strconv.Atoi(match[1])
+		buildErr.Column, _ = strconv.Atoi(match[2])
+		buildErr.Message = match[3]
+	}
+
+	buildErr.Frame = codeFrame(file, buildErr.Line, buildErr.Column)
+	return buildErr
+}
+
+// newBundlerErrors converts esbuild's messages into build errors. Messages
+// from the virtual modules that melange generates are attributed to the
+// page that they were generated for.
+func newBundlerErrors(site *Site, messages []api.Message) BuildErrors {
+	var errs BuildErrors
+
+	for _, msg := range messages {
+		err := &BuildError{Category: BundlerError, Message: msg.Text}
+
+		if msg.Location != nil {
+			if page := site.pageFromModule(msg.Location.File); page != nil {
+				err.File = page.absPath
+			} else {
+				err.File = site.absPath(msg.Location.File)
+				err.Line = msg.Location.Line
+				err.Column = msg.Location.Column + 1
+				err.Frame = codeFrame(err.File, err.Line, err.Column)
+			}
+		}
+
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// codeFrame returns the lines surrounding a line in a file, with a marker
+// pointing at the column.
+func codeFrame(file string, line int, column int) string {
+	source, err := os.ReadFile(file)
+
+	if err != nil || line <= 0 {
+		return ""
+	}
+
+	lines := strings.Split(string(source), "\n")
+
+	if line > len(lines) {
+		return ""
+	}
+
+	var frame strings.Builder
+	start := line - 2
+	end := line + 2
+
+	if start < 1 {
+		start = 1
+	}
+
+	if end > len(lines) {
+		end = len(lines)
+	}
+	width := len(strconv.Itoa(end))
+
+	for i := start; i <= end; i++ {
+		marker := " "
+
+		if i == line {
+			marker = ">"
+		}
+
+		frame.WriteString(fmt.Sprintf("%s %*d | %s\n", marker, width, i, lines[i-1]))
+
+		if i == line && column > 0 {
+			frame.WriteString(fmt.Sprintf("  %s | %s^\n", strings.Repeat(" ", width), strings.Repeat(" ", column-1)))
+		}
+	}
+
+	return frame.String()
+}
+
+// AsBuildErrors returns the build errors that err wraps, or nil if it
+// doesn't wrap any.
+func AsBuildErrors(err error) BuildErrors {
+	var errs BuildErrors
+	var buildErr *BuildError
+
+	if errors.As(err, &errs) {
+		return errs
+	} else if errors.As(err, &buildErr) {
+		return BuildErrors{buildErr}
+	}
+
+	return nil
+}
+
+// jsError is an exception that was thrown while rendering.
+type jsError struct {
+	Message string `json:"message"`
+	Stack   string `json:"stack"`
+}
+
+// renderResponse is the render host's reply to a request to render a static
+// bundle.
+type renderResponse struct {
+	Html   map[string]string   `json:"html"`
+	Errors map[string]*jsError `json:"errors"`
+	// Error is set if the bundle couldn't be loaded at all.
+	Error *jsError `json:"error"`
+}
+
+// buildErrors converts the exceptions in a response into build errors,
+// mapped back to the original sources through the bundle's source map.
+func (response *renderResponse) buildErrors(site *Site, bundle string) error {
+	if response.Error == nil && len(response.Errors) == 0 {
+		return nil
+	}
+
+	sm, _ := readSourceMap(bundle + ".map")
+
+	if response.Error != nil {
+		return newRuntimeError(response.Error, sm, bundle, "")
+	}
+
+	var errs BuildErrors
+
+	for _, page := range site.Pages() {
+		for _, element := range page.elements {
+			if jsErr, ok := response.Errors[element.id]; ok {
+				context := fmt.Sprintf("rendering %s in %s", element.src, page.relPath)
+				errs = append(errs, newRuntimeError(jsErr, sm, bundle, context))
+			}
+		}
+	}
+
+	return errs
+}
+
+var stackFrameRegex = regexp.MustCompile(`^(\s*at (?:.*? \()?)(.+?):(\d+):(\d+)(\)?)$`)
+
+// newRuntimeError maps each frame of a stack trace from the bundle back to
+// its source. The error is reported at the first frame that belongs to the
+// site's own code, rather than a dependency.
+func newRuntimeError(jsErr *jsError, sm *sourceMap, bundle string, context string) *BuildError {
+	err := &BuildError{Category: RuntimeError, File: bundle, Message: jsErr.Message}
+
+	if context != "" {
+		err.Message = fmt.Sprintf("%s (%s)", jsErr.Message, context)
+	}
+
+	var stack []string
+	located := false
+
+	for _, line := range strings.Split(jsErr.Stack, "\n") {
+		match := stackFrameRegex.FindStringSubmatch(line)
+
+		if match == nil || match[2] != bundle || sm == nil {
+			stack = append(stack, line)
+			continue
+		}
+
+		genLine, _ := strconv.Atoi(match[3])
+		genColumn, _ := strconv.Atoi(match[4])
+		source, line, column, ok := sm.lookup(genLine, genColumn)
+
+		if !ok {
+			stack = append(stack, match[0])
+			continue
+		}
+
+		stack = append(stack, fmt.Sprintf("%s%s:%d:%d%s", match[1], source, line, column, match[5]))
+
+		if !located && path.IsAbs(source) && !strings.Contains(source, "/node_modules/") {
+			err.File, err.Line, err.Column = source, line, column
+			err.Frame = codeFrame(source, line, column)
+			located = true
+		}
+	}
+
+	err.Stack = strings.Join(stack, "\n")
+	return err
+}
@@ -0,0 +1,42 @@
+package melange
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestNewTemplateError(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"pages/index.md": "one\ntwo\n{{ .Nope }}\nfour",
+	})
+
+	file := path.Join(dir, "pages/index.md")
+	err := newTemplateError(TemplateExecError, file, errors.New(`template: page:3:3: executing "page" at <.Nope>: can't evaluate field Nope`))
+
+	if err.Line != 3 || err.Column != 3 {
+		t.Fatalf("expected error at 3:3, got %d:%d", err.Line, err.Column)
+	}
+
+	if !strings.HasPrefix(err.Message, "executing") {
+		t.Fatalf("expected location to be stripped from message: %s", err.Message)
+	}
+
+	if !strings.Contains(err.Frame, "> 3 | {{ .Nope }}") {
+		t.Fatalf("expected frame to point at the failing line:\n%s", err.Frame)
+	}
+}
+
+func TestAsBuildErrors(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", &BuildError{Category: MarkdownError, Message: "oops"})
+
+	if errs := AsBuildErrors(err); len(errs) != 1 || errs[0].Category != MarkdownError {
+		t.Fatalf("expected wrapped build error to be found: %v", errs)
+	}
+
+	if errs := AsBuildErrors(errors.New("plain")); errs != nil {
+		t.Fatalf("expected plain errors to be ignored")
+	}
+}
@@ -1,68 +1,108 @@
-package main
+package melange
 
 import (
 	"fmt"
 	"path"
+	"sort"
 	"strings"
 
 	"github.com/evanw/esbuild/pkg/api"
 )
 
 type framework struct {
+	name           string
 	esbuildOptions api.BuildOptions
 	staticExternal []string
-	staticBundle   func(config *config) string
-	clientBundle   func(page *page) string
+	staticBundle   func(elements []*Element) string
+	clientBundle   func(elements []*Element) string
+	// plugin is an optional esbuild plugin for frameworks that need their own
+	// compile step.
+	plugin func(site *Site, ssr bool) api.Plugin
+	// compilerPackages are the packages that the compile step needs, in
+	// addition to staticExternal.
+	compilerPackages []string
+}
+
+const clientRuntimeImport = "import { island, onVisible, onIdle, onMedia } from \"melange:runtime\";\n"
+
+// writeHydration writes the code that mounts a single element. Elements
+// without a trigger are imported eagerly and mounted as soon as the bundle
+// runs. Triggered elements are imported lazily, so that their code is only
+// fetched once the trigger fires.
+//
+// The mount expression is a function that takes the element's container and
+// whether it should hydrate the server rendered HTML, and returns a function
+// that unmounts it again. It must refer to the component by the element's id.
+// The runtime uses it to mount the element and to remount it during hot
+// module replacement.
+func writeHydration(builder *strings.Builder, element *Element, mount string) {
+	trigger := element.trigger()
+
+	if trigger == "" {
+		builder.WriteString(fmt.Sprintf(
+			"import { default as %s } from \"%s\";\n",
+			element.id,
+			element.src,
+		))
+		builder.WriteString(fmt.Sprintf(
+			"island(\"%s\", %t, %s);\n",
+			element.id,
+			element.ssr,
+			mount,
+		))
+		return
+	}
+
+	builder.WriteString(fmt.Sprintf(
+		"%s() => import(\"%s\").then(({ default: %s }) => island(\"%s\", %t, %s)));\n",
+		trigger,
+		element.src,
+		element.id,
+		element.id,
+		element.ssr,
+		mount,
+	))
 }
 
 var preact = framework{
+	name:           "preact",
 	staticExternal: []string{"preact", "preact-render-to-string"},
 	esbuildOptions: api.BuildOptions{},
-	staticBundle: func(config *config) string {
+	staticBundle: func(elements []*Element) string {
 		var builder strings.Builder
 
 		builder.WriteString("import { h } from \"preact\";\n")
 		builder.WriteString("import { render } from \"preact-render-to-string\";\n")
-		builder.WriteString("let elements = {};")
-
-		for _, page := range config.pages {
-			for _, element := range page.elements {
-				if element.ssr {
-					builder.WriteString(fmt.Sprintf(
-						"import { default as C%s } from \"%s\";\n",
-						element.id,
-						path.Join(page.dir, element.src),
-					))
-					builder.WriteString(fmt.Sprintf(
-						"elements.%s = render(h(C%s, null));\n",
-						element.id,
-						element.id,
-					))
-				}
-			}
+		builder.WriteString("let elements = {};\n")
+
+		for _, element := range elements {
+			builder.WriteString(fmt.Sprintf(
+				"import { default as C%s } from \"%s\";\n",
+				element.id,
+				path.Join(element.dir, element.src),
+			))
+			builder.WriteString(fmt.Sprintf(
+				"elements.%s = () => render(h(C%s, %s));\n",
+				element.id,
+				element.id,
+				toJson(&element.props),
+			))
 		}
 
-		builder.WriteString("process.stdout.write(JSON.stringify(elements))")
+		builder.WriteString("export default elements;\n")
 		return builder.String()
 	},
-	clientBundle: func(page *page) string {
+	clientBundle: func(elements []*Element) string {
 		var builder strings.Builder
-		builder.WriteString("import { h, hydrate, Fragment } from \"preact\";\n")
-
-		for _, element := range page.elements {
-			if element.csr {
-				builder.WriteString(fmt.Sprintf(
-					"import { default as %s } from \"%s\";\n",
-					element.id,
-					element.src,
-				))
-				builder.WriteString(fmt.Sprintf(
-					"hydrate(h(%s, %s), document.getElementById(\"%s\"));\n",
-					element.id,
-					toJson(&element.props),
-					element.id,
-				))
-			}
+		builder.WriteString("import { h, hydrate, render, Fragment } from \"preact\";\n")
+		builder.WriteString(clientRuntimeImport)
+
+		for _, element := range elements {
+			writeHydration(&builder, element, fmt.Sprintf(
+				"(el, hydrating) => { (hydrating ? hydrate : render)(h(%s, %s), el); return () => render(null, el); }",
+				element.id,
+				toJson(&element.props),
+			))
 		}
 
 		return builder.String()
@@ -70,54 +110,274 @@ var preact = framework{
 }
 
 var react = framework{
+	name:           "react",
 	staticExternal: []string{"react", "react-dom"},
 	esbuildOptions: api.BuildOptions{},
-	staticBundle: func(config *config) string {
+	staticBundle: func(elements []*Element) string {
 		var builder strings.Builder
 
 		builder.WriteString("import * as React from \"react\";\n")
 		builder.WriteString("import { renderToString } from \"react-dom/server\";\n")
 		builder.WriteString("let elements = {};\n")
 
-		for _, page := range config.pages {
-			for _, element := range page.elements {
-				builder.WriteString(fmt.Sprintf(
-					"import C%s from \"%s\";\n",
-					element.id,
-					path.Join(page.dir, element.src),
-				))
-				builder.WriteString(fmt.Sprintf(
-					"elements.%s = renderToString(React.createElement(C%s));\n",
-					element.id,
-					element.id,
-				))
-			}
+		for _, element := range elements {
+			builder.WriteString(fmt.Sprintf(
+				"import C%s from \"%s\";\n",
+				element.id,
+				path.Join(element.dir, element.src),
+			))
+			builder.WriteString(fmt.Sprintf(
+				"elements.%s = () => renderToString(React.createElement(C%s, %s));\n",
+				element.id,
+				element.id,
+				toJson(&element.props),
+			))
 		}
 
-		builder.WriteString("process.stdout.write(JSON.stringify(elements))")
+		builder.WriteString("export default elements;\n")
 		return builder.String()
 	},
-	clientBundle: func(page *page) string {
+	clientBundle: func(elements []*Element) string {
 		var builder strings.Builder
 		builder.WriteString("import * as React from \"react\";\n")
-		builder.WriteString("import { hydrateRoot } from \"react-dom/client\";\n")
-
-		for _, element := range page.elements {
-			if element.csr {
-				builder.WriteString(fmt.Sprintf(
-					"import { default as %s } from \"%s\";\n",
-					element.id,
-					element.src,
-				))
-				builder.WriteString(fmt.Sprintf(
-					"hydrateRoot(document.getElementById(\"%s\"), React.createElement(%s, %s));\n",
-					element.id,
-					element.id,
-					toJson(&element.props),
-				))
-			}
+		builder.WriteString("import { createRoot, hydrateRoot } from \"react-dom/client\";\n")
+		builder.WriteString(clientRuntimeImport)
+
+		for _, element := range elements {
+			writeHydration(&builder, element, fmt.Sprintf(
+				"(el, hydrating) => { let vnode = React.createElement(%s, %s); let root = hydrating ? hydrateRoot(el, vnode) : createRoot(el); hydrating || root.render(vnode); return () => root.unmount(); }",
+				element.id,
+				toJson(&element.props),
+			))
+		}
+
+		return builder.String()
+	},
+}
+
+var svelte = framework{
+	name:           "svelte",
+	staticExternal: []string{"svelte"},
+	esbuildOptions: api.BuildOptions{},
+	staticBundle: func(elements []*Element) string {
+		var builder strings.Builder
+		builder.WriteString("let elements = {};\n")
+
+		for _, element := range elements {
+			builder.WriteString(fmt.Sprintf(
+				"import C%s from \"%s\";\n",
+				element.id,
+				path.Join(element.dir, element.src),
+			))
+			builder.WriteString(fmt.Sprintf(
+				"elements.%s = () => C%s.render(%s).html;\n",
+				element.id,
+				element.id,
+				toJson(&element.props),
+			))
+		}
+
+		builder.WriteString("export default elements;\n")
+		return builder.String()
+	},
+	clientBundle: func(elements []*Element) string {
+		var builder strings.Builder
+		builder.WriteString(clientRuntimeImport)
+
+		for _, element := range elements {
+			writeHydration(&builder, element, fmt.Sprintf(
+				"(el, hydrating) => { let app = new %s({ target: el, props: %s, hydrate: hydrating }); return () => app.$destroy(); }",
+				element.id,
+				toJson(&element.props),
+			))
+		}
+
+		return builder.String()
+	},
+	plugin: func(site *Site, ssr bool) api.Plugin {
+		return compilerPlugin(site, "svelte", `\.svelte$`, api.LoaderJS, ssr)
+	},
+}
+
+var solid = framework{
+	name:           "solid",
+	staticExternal: []string{"solid-js"},
+	esbuildOptions: api.BuildOptions{},
+	staticBundle: func(elements []*Element) string {
+		var builder strings.Builder
+
+		builder.WriteString("import { renderToString, createComponent } from \"solid-js/web\";\n")
+		builder.WriteString("let elements = {};\n")
+
+		for _, element := range elements {
+			builder.WriteString(fmt.Sprintf(
+				"import C%s from \"%s\";\n",
+				element.id,
+				path.Join(element.dir, element.src),
+			))
+			builder.WriteString(fmt.Sprintf(
+				"elements.%s = () => renderToString(() => createComponent(C%s, %s), { renderId: \"%s\" });\n",
+				element.id,
+				element.id,
+				toJson(&element.props),
+				element.id,
+			))
+		}
+
+		builder.WriteString("export default elements;\n")
+		return builder.String()
+	},
+	clientBundle: func(elements []*Element) string {
+		var builder strings.Builder
+		builder.WriteString("import { hydrate, render, createComponent } from \"solid-js/web\";\n")
+		builder.WriteString(clientRuntimeImport)
+		// Solid's hydration expects the global that its hydration script would
+		// usually create.
+		builder.WriteString("window._$HY ||= { events: [], completed: new WeakSet(), r: {} };\n")
+
+		for _, element := range elements {
+			writeHydration(&builder, element, fmt.Sprintf(
+				"(el, hydrating) => (hydrating ? hydrate : render)(() => createComponent(%s, %s), el, { renderId: \"%s\" })",
+				element.id,
+				toJson(&element.props),
+				element.id,
+			))
+		}
+
+		return builder.String()
+	},
+	plugin:           solidPlugin,
+	compilerPackages: []string{"@babel/core", "babel-preset-solid", "@babel/preset-typescript"},
+}
+
+var vue = framework{
+	name:           "vue",
+	staticExternal: []string{"vue"},
+	esbuildOptions: api.BuildOptions{
+		Define: map[string]string{
+			"__VUE_OPTIONS_API__":   "true",
+			"__VUE_PROD_DEVTOOLS__": "false",
+		},
+	},
+	staticBundle: func(elements []*Element) string {
+		var builder strings.Builder
+
+		builder.WriteString("import { createSSRApp } from \"vue\";\n")
+		builder.WriteString("import { renderToString } from \"vue/server-renderer\";\n")
+		builder.WriteString("let elements = {};\n")
+
+		for _, element := range elements {
+			builder.WriteString(fmt.Sprintf(
+				"import C%s from \"%s\";\n",
+				element.id,
+				path.Join(element.dir, element.src),
+			))
+			// Vue renders asynchronously, the host waits for these promises.
+			builder.WriteString(fmt.Sprintf(
+				"elements.%s = () => renderToString(createSSRApp(C%s, %s));\n",
+				element.id,
+				element.id,
+				toJson(&element.props),
+			))
+		}
+
+		builder.WriteString("export default elements;\n")
+		return builder.String()
+	},
+	clientBundle: func(elements []*Element) string {
+		var builder strings.Builder
+		builder.WriteString("import { createApp, createSSRApp } from \"vue\";\n")
+		builder.WriteString(clientRuntimeImport)
+
+		for _, element := range elements {
+			writeHydration(&builder, element, fmt.Sprintf(
+				"(el, hydrating) => { let app = (hydrating ? createSSRApp : createApp)(%s, %s); app.mount(el); return () => app.unmount(); }",
+				element.id,
+				toJson(&element.props),
+			))
 		}
 
 		return builder.String()
 	},
+	plugin: func(site *Site, ssr bool) api.Plugin {
+		return compilerPlugin(site, "vue", `\.vue$`, api.LoaderTS, ssr)
+	},
+	compilerPackages: []string{"@vue/compiler-sfc"},
+}
+
+var frameworks = map[string]*framework{
+	preact.name: &preact,
+	react.name:  &react,
+	svelte.name: &svelte,
+	solid.name:  &solid,
+	vue.name:    &vue,
+}
+
+func getFramework(name string) (*framework, error) {
+	if fw, ok := frameworks[name]; ok {
+		return fw, nil
+	}
+
+	return nil, fmt.Errorf("unknown framework %q", name)
+}
+
+func frameworkNames() []string {
+	var names []string
+
+	for name := range frameworks {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// groupByFramework splits elements into the frameworks that render them.
+// Each framework's elements are bundled as a separate module, which keeps
+// their imports apart when multiple frameworks are used together.
+func groupByFramework(elements []*Element) map[*framework][]*Element {
+	groups := map[*framework][]*Element{}
+
+	for _, element := range elements {
+		groups[element.framework] = append(groups[element.framework], element)
+	}
+
+	return groups
+}
+
+func sortedFrameworks(groups map[*framework][]*Element) []*framework {
+	var fws []*framework
+
+	for fw := range groups {
+		fws = append(fws, fw)
+	}
+
+	sort.Slice(fws, func(i, j int) bool {
+		return fws[i].name < fws[j].name
+	})
+
+	return fws
+}
+
+// frameworkPlugins returns the compile plugins for the given frameworks.
+func frameworkPlugins(site *Site, fws []*framework, ssr bool) []api.Plugin {
+	var plugins []api.Plugin
+
+	for _, fw := range fws {
+		if fw.plugin != nil {
+			plugins = append(plugins, fw.plugin(site, ssr))
+		}
+	}
+
+	return plugins
+}
+
+func uniqueFrameworks(fws []*framework) []*framework {
+	groups := map[*framework][]*Element{}
+
+	for _, fw := range fws {
+		groups[fw] = nil
+	}
+
+	return sortedFrameworks(groups)
 }
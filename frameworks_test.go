@@ -0,0 +1,123 @@
+package melange
+
+import (
+	"strings"
+	"testing"
+)
+
+func createTestPage() *Page {
+	return &Page{id: "test", dir: "/site/pages", relPath: "/index.md"}
+}
+
+func TestFrameworkPropsMatch(t *testing.T) {
+	for name, fw := range frameworks {
+		p := createTestPage()
+		el := p.addElement("./counter.tsx", parseProps("count", 1, "label", "</script>"))
+		el.csr = true
+
+		props := toJson(&el.props)
+		static := fw.staticBundle(p.elements)
+		client := fw.clientBundle(p.elements)
+
+		if !strings.Contains(static, props) {
+			t.Fatalf("%s: expected static bundle to render with props %s", name, props)
+		}
+
+		if !strings.Contains(client, props) {
+			t.Fatalf("%s: expected client bundle to hydrate with props %s", name, props)
+		}
+	}
+}
+
+func TestPropsEscaping(t *testing.T) {
+	props := parseProps("label", "</script><script>alert(1)</script>")
+	json := toJson(&props)
+
+	if strings.Contains(json, "</script>") {
+		t.Fatalf("expected props to escape script tags: %s", json)
+	}
+}
+
+func TestTriggeredElementsAreImportedLazily(t *testing.T) {
+	p := createTestPage()
+	eager := p.addElement("./eager.tsx", parseProps())
+	eager.csr = true
+	visible := p.addElement("./visible.tsx", parseProps())
+	visible.csr = true
+	visible.visible = true
+	media := p.addElement("./media.tsx", parseProps())
+	media.csr = true
+	media.media = "(max-width: 600px)"
+
+	client := preact.clientBundle(p.elements)
+
+	if !strings.Contains(client, `import { default as `+eager.id+` } from "./eager.tsx"`) {
+		t.Fatalf("expected eager element to be imported statically:\n%s", client)
+	}
+
+	if !strings.Contains(client, `onVisible(document.getElementById("`+visible.id+`"), () => import("./visible.tsx")`) {
+		t.Fatalf("expected visible element to be imported when visible:\n%s", client)
+	}
+
+	if !strings.Contains(client, `onMedia("(max-width: 600px)", () => import("./media.tsx")`) {
+		t.Fatalf("expected media element to be imported when the query matches:\n%s", client)
+	}
+}
+
+func TestGroupByFramework(t *testing.T) {
+	p := createTestPage()
+	a := p.addElement("./a.tsx", parseProps())
+	a.framework = &preact
+	b := p.addElement("./b.tsx", parseProps())
+	b.framework = &react
+	c := p.addElement("./c.tsx", parseProps())
+	c.framework = &preact
+
+	groups := groupByFramework(p.elements)
+
+	if len(groups[&preact]) != 2 || len(groups[&react]) != 1 {
+		t.Fatalf("expected elements to be grouped by framework: %v", groups)
+	}
+
+	fws := sortedFrameworks(groups)
+
+	if fws[0] != &preact || fws[1] != &react {
+		t.Fatalf("expected frameworks to be sorted by name")
+	}
+}
+
+func TestClientOnlyElementsAreNotRendered(t *testing.T) {
+	for name, fw := range frameworks {
+		mixed := &Page{id: "mixed", dir: "/site/pages", relPath: "/mixed.md"}
+		rendered := mixed.addElement("./rendered.tsx", parseProps())
+		skipped := mixed.addElement("./skipped.tsx", parseProps())
+		skipped.csr = true
+		skipped.ssr = false
+
+		clientOnly := &Page{id: "client", dir: "/site/pages", relPath: "/client.md"}
+		other := clientOnly.addElement("./other.tsx", parseProps())
+		other.csr = true
+		other.ssr = false
+
+		for _, el := range []*Element{rendered, skipped, other} {
+			el.framework = fw
+		}
+
+		site := &Site{pages: map[string]*Page{mixed.id: mixed, clientOnly.id: clientOnly}}
+		chunks := splitStaticChunks(site, 4)
+
+		if len(chunks) != 1 || len(chunks[0].groups[fw]) != 1 {
+			t.Fatalf("%s: expected a single chunk with the rendered element, got %v", name, chunks)
+		}
+
+		static := fw.staticBundle(chunks[0].groups[fw])
+
+		if !strings.Contains(static, rendered.id) {
+			t.Fatalf("%s: expected static bundle to render the element:\n%s", name, static)
+		}
+
+		if strings.Contains(static, skipped.id) || strings.Contains(static, other.id) {
+			t.Fatalf("%s: expected client only elements to be excluded from the static bundle:\n%s", name, static)
+		}
+	}
+}
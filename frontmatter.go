@@ -0,0 +1,75 @@
+package melange
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// parseFrontMatter parses the YAML between the "---" lines at the start of
+// a page. It understands the same syntax as the markdown renderer, so that
+// a page's metadata is known before any templates run.
+func parseFrontMatter(contents []byte) (map[string]any, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != "---" {
+		return map[string]any{}, nil
+	}
+
+	var lines []string
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if trimmed := strings.TrimSpace(line); trimmed == "---" || trimmed == "..." {
+			data := map[string]any{}
+			err := yaml.Unmarshal([]byte(strings.Join(lines, "\n")), &data)
+			return data, err
+		}
+
+		lines = append(lines, line)
+	}
+
+	// The front matter was never closed, so the renderer treats it as markdown
+	return map[string]any{}, nil
+}
+
+var headingRegex = regexp.MustCompile(`(?m)^#[ \t]+(.+?)[ \t#]*$`)
+
+// pageTitle is the title from the page's front matter, or its first
+// top-level heading, or its file name.
+func pageTitle(data map[string]any, contents []byte, name string) string {
+	if title, ok := data["title"]; ok && title != nil {
+		return fmt.Sprint(title)
+	}
+
+	if match := headingRegex.FindSubmatch(contents); match != nil {
+		return string(match[1])
+	}
+
+	return strings.TrimSuffix(name, ".md")
+}
+
+// yamlLineRegex matches the line number in YAML errors.
+var yamlLineRegex = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// newFrontMatterError converts a YAML error into a build error. The lines
+// in YAML errors are relative to the front matter, which starts on the line
+// after the opening "---".
+func newFrontMatterError(file string, err error) *BuildError {
+	buildErr := &BuildError{Category: MarkdownError, File: file, Message: err.Error()}
+
+	if match := yamlLineRegex.FindStringSubmatch(err.Error()); match != nil {
+		line, _ := strconv.Atoi(match[1])
+		buildErr.Line = line + 1
+		buildErr.Message = "front matter: " + match[2]
+		buildErr.Frame = codeFrame(file, buildErr.Line, 0)
+	}
+
+	return buildErr
+}
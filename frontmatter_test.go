@@ -0,0 +1,75 @@
+package melange
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestParseFrontMatter(t *testing.T) {
+	data, err := parseFrontMatter([]byte("---\ntitle: Hello\ntags: [a, b]\n---\n# Body"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data["title"] != "Hello" || len(data["tags"].([]any)) != 2 {
+		t.Fatalf("unexpected front matter %v", data)
+	}
+
+	for _, source := range []string{"# No front matter", "---\ntitle: Unclosed", ""} {
+		if data, err := parseFrontMatter([]byte(source)); err != nil || len(data) != 0 {
+			t.Fatalf("expected no front matter in %q, got %v %v", source, data, err)
+		}
+	}
+}
+
+func TestPageTitle(t *testing.T) {
+	tests := []struct {
+		data     map[string]any
+		contents string
+		expected string
+	}{
+		{map[string]any{"title": "Front"}, "# Heading", "Front"},
+		{map[string]any{}, "Intro\n\n# Heading #\n\n# Other", "Heading"},
+		{map[string]any{}, "No headings", "about"},
+	}
+
+	for _, test := range tests {
+		if title := pageTitle(test.data, []byte(test.contents), "about.md"); title != test.expected {
+			t.Fatalf("expected title %q, got %q", test.expected, title)
+		}
+	}
+}
+
+func TestFrontMatterError(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"pages/index.md": "---\ntitle: ok\ntags: [a\n---\n",
+	})
+
+	_, err := Build(BuildOptions{Dir: dir})
+	errs := AsBuildErrors(err)
+
+	if len(errs) != 1 || errs[0].Category != MarkdownError || errs[0].Line == 0 {
+		t.Fatalf("expected front matter error with a line, got %v", err)
+	}
+}
+
+func TestMetadataBeforeRendering(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"pages/a.md": "{{ range .Site.Pages }}[{{ .Title }} {{ .Url }}]{{ end }}",
+		"pages/b.md": "---\ntitle: Bee\n---\n",
+		"pages/c.md": "# Sea",
+	})
+
+	if _, err := Build(BuildOptions{Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	html, _ := os.ReadFile(path.Join(dir, "_site/a.html"))
+
+	if !strings.Contains(string(html), "[Bee /b.html][Sea /c.html]") {
+		t.Fatalf("expected sibling metadata to be available:\n%s", html)
+	}
+}
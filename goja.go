@@ -0,0 +1,152 @@
+package melange
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// gojaRenderer evaluates static bundles with goja, a JavaScript engine that
+// is written in Go, so that sites can be rendered without node. Bundles for
+// goja include their dependencies, because there's no node_modules to
+// require them from at runtime.
+type gojaRenderer struct {
+	timeout time.Duration
+}
+
+func newGojaRenderer() *gojaRenderer {
+	return &gojaRenderer{timeout: 30 * time.Second}
+}
+
+// render evaluates a bundle in a fresh runtime. Runtimes can't be shared
+// between goroutines, so concurrent renders don't block each other.
+func (renderer *gojaRenderer) render(bundle string) (*renderResponse, error) {
+	source, err := os.ReadFile(bundle)
+
+	if err != nil {
+		return nil, err
+	}
+
+	vm := goja.New()
+	timer := time.AfterFunc(renderer.timeout, func() {
+		vm.Interrupt(fmt.Sprintf("goja timed out after %s rendering %s", renderer.timeout, bundle))
+	})
+	defer timer.Stop()
+
+	response := &renderResponse{Html: map[string]string{}, Errors: map[string]*jsError{}}
+	module := vm.NewObject()
+	module.Set("exports", vm.NewObject())
+	vm.Set("module", module)
+	vm.Set("exports", module.Get("exports"))
+	vm.Set("console", gojaConsole(vm))
+	vm.Set("require", func(name string) goja.Value {
+		panic(vm.NewGoError(fmt.Errorf("can't require %q without node", name)))
+	})
+
+	// The bundle runs as a script rather than inside a function wrapper,
+	// so that its positions still line up with its source map.
+	if _, err := vm.RunScript(bundle, string(source)); err != nil {
+		if interrupted, ok := err.(*goja.InterruptedError); ok {
+			return nil, fmt.Errorf("%s", interrupted.Value())
+		}
+
+		response.Error = gojaError(err)
+		return response, nil
+	}
+
+	elements := module.Get("exports").ToObject(vm)
+	ids := elements.Keys()
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		render, ok := goja.AssertFunction(elements.Get(id))
+
+		if !ok {
+			continue
+		}
+
+		value, err := render(goja.Undefined())
+
+		if interrupted, ok := err.(*goja.InterruptedError); ok {
+			return nil, fmt.Errorf("%s", interrupted.Value())
+		}
+
+		if err != nil {
+			response.Errors[id] = gojaError(err)
+			continue
+		}
+
+		// Some frameworks render asynchronously. Promise jobs have already
+		// run by the time the call returns, so the promise should be settled.
+		if promise, ok := value.Export().(*goja.Promise); ok {
+			switch promise.State() {
+			case goja.PromiseStateFulfilled:
+				value = promise.Result()
+			case goja.PromiseStateRejected:
+				response.Errors[id] = gojaValueError(promise.Result(), "")
+				continue
+			default:
+				response.Errors[id] = &jsError{Message: "render promise never settled"}
+				continue
+			}
+		}
+
+		response.Html[id] = value.String()
+	}
+
+	return response, nil
+}
+
+// Close has nothing to clean up, because goja runtimes are garbage
+// collected.
+func (renderer *gojaRenderer) Close() error {
+	return nil
+}
+
+// gojaConsole discards logs, like the node host does.
+func gojaConsole(vm *goja.Runtime) *goja.Object {
+	console := vm.NewObject()
+	noop := func(goja.FunctionCall) goja.Value { return goja.Undefined() }
+
+	for _, name := range []string{"log", "info", "warn", "error", "debug", "trace"} {
+		console.Set(name, noop)
+	}
+
+	return console
+}
+
+// gojaPositionRegex matches the program counter that goja appends to the
+// positions in its stack traces.
+var gojaPositionRegex = regexp.MustCompile(`(:\d+:\d+)\(\d+\)`)
+
+// gojaError converts an exception into the same shape that the node host
+// reports, with stack frames formatted like V8's, so that they can be mapped
+// through source maps.
+func gojaError(err error) *jsError {
+	if exception, ok := err.(*goja.Exception); ok {
+		return gojaValueError(exception.Value(), exception.String())
+	}
+
+	return &jsError{Message: err.Error()}
+}
+
+func gojaValueError(value goja.Value, fallbackStack string) *jsError {
+	jsErr := &jsError{Message: value.String(), Stack: fallbackStack}
+
+	if object, ok := value.(*goja.Object); ok {
+		if message := object.Get("message"); message != nil && !goja.IsUndefined(message) {
+			jsErr.Message = message.String()
+		}
+
+		if stack := object.Get("stack"); stack != nil && !goja.IsUndefined(stack) {
+			jsErr.Stack = stack.String()
+		}
+	}
+
+	jsErr.Stack = gojaPositionRegex.ReplaceAllString(jsErr.Stack, "$1")
+	return jsErr
+}
@@ -0,0 +1,97 @@
+package melange
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGojaRender(t *testing.T) {
+	dir := t.TempDir()
+	renderer := newGojaRenderer()
+
+	bundle := writeBundle(t, dir, "ok.js", `
+		module.exports = {
+			a: () => "<p>a</p>",
+			b: async () => "<p>b</p>",
+			c: () => { throw new Error("broken") },
+			d: async () => { throw new Error("rejected") },
+		};
+	`)
+
+	response, err := renderer.render(bundle)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Html["a"] != "<p>a</p>" || response.Html["b"] != "<p>b</p>" {
+		t.Fatalf("unexpected html %v", response.Html)
+	}
+
+	if response.Errors["c"] == nil || response.Errors["c"].Message != "broken" {
+		t.Fatalf("expected errors to be reported per element: %v", response.Errors)
+	}
+
+	if response.Errors["d"] == nil || response.Errors["d"].Message != "rejected" {
+		t.Fatalf("expected rejected promises to be reported: %v", response.Errors)
+	}
+
+	frame := strings.Split(response.Errors["c"].Stack, "\n")[1]
+
+	if !stackFrameRegex.MatchString(frame) || !strings.Contains(frame, "ok.js:5:") {
+		t.Fatalf("expected stack frames to be formatted like node's, got %q", frame)
+	}
+}
+
+func TestGojaRenderRequire(t *testing.T) {
+	dir := t.TempDir()
+	renderer := newGojaRenderer()
+	bundle := writeBundle(t, dir, "require.js", `require("preact");`)
+	response, err := renderer.render(bundle)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Error == nil || !strings.Contains(response.Error.Message, `"preact"`) {
+		t.Fatalf("expected require to fail, got %v", response.Error)
+	}
+}
+
+func TestGojaRenderTimeout(t *testing.T) {
+	dir := t.TempDir()
+	renderer := newGojaRenderer()
+	renderer.timeout = 100 * time.Millisecond
+	hang := writeBundle(t, dir, "hang.js", `module.exports = { a: () => { while (true) {} } };`)
+
+	if _, err := renderer.render(hang); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected render to time out, got %v", err)
+	}
+}
+
+func TestGojaBuildDefinesNodeEnv(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"pages/_theme.html":                   `{{ .Page.Contents | safeHTML }}`,
+		"pages/index.md":                      `{{ render "./_mode.js" }}`,
+		"pages/_mode.js":                      `export default () => null;`,
+		"node_modules/react/package.json":     `{"main": "index.js"}`,
+		"node_modules/react/index.js":         `module.exports = { createElement: () => "<p>" + process.env.NODE_ENV + "</p>" };`,
+		"node_modules/react-dom/package.json": `{"main": "index.js"}`,
+		"node_modules/react-dom/server.js":    `module.exports = { renderToString: html => html };`,
+	})
+
+	for mode, production := range map[string]bool{"development": false, "production": true} {
+		if _, err := Build(BuildOptions{Dir: dir, Framework: "react", Runtime: "goja", Production: production}); err != nil {
+			t.Fatal(err)
+		}
+
+		html, _ := os.ReadFile(path.Join(dir, "_site/index.html"))
+
+		if !strings.Contains(string(html), "<p>"+mode+"</p>") {
+			t.Fatalf("expected packages to read %s from process.env, got %q", mode, html)
+		}
+	}
+}
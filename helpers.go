@@ -1,9 +1,10 @@
-package main
+package melange
 
 import (
 	"encoding/json"
 	"fmt"
 	"hash/fnv"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
@@ -26,12 +27,23 @@ func isPageFile(name string) bool {
 	return filepath.Ext(name) == ".md"
 }
 
+// resolvePath resolves a path relative to dir, unless it's already absolute.
+func resolvePath(dir string, name string) string {
+	if path.IsAbs(name) {
+		return name
+	}
+
+	return path.Join(dir, name)
+}
+
 func shortHash(s string) string {
 	h := fnv.New32a()
 	h.Write([]byte(s))
 	return fmt.Sprintf("%x", h.Sum32())
 }
 
+// toJson serializes props for embedding in generated bundles. The encoder
+// escapes <, > and & so that props can never close a surrounding script tag.
 func toJson(props *props) string {
 	out, _ := json.Marshal(props)
 	return string(out)
@@ -0,0 +1,221 @@
+package melange
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// incrementalBuild keeps esbuild's incremental state for the client bundles
+// between the dev server's builds. The previous result is only reused when
+// the generated page modules are unchanged, because the plugins that
+// generate them hold on to the site from the build that created them.
+type incrementalBuild struct {
+	mu     sync.Mutex
+	key    string
+	result *api.BuildResult
+}
+
+func (ib *incrementalBuild) build(options api.BuildOptions, key string) api.BuildResult {
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+
+	var result api.BuildResult
+
+	if ib.result != nil && ib.key == key {
+		result = ib.result.Rebuild()
+	} else {
+		result = api.Build(options)
+	}
+
+	ib.key = key
+	ib.result = nil
+
+	if result.Rebuild != nil {
+		ib.result = &result
+	}
+
+	return result
+}
+
+// clientBundlesKey identifies the generated modules for every page's client
+// bundle.
+func clientBundlesKey(site *Site) string {
+	h := sha1.New()
+
+	for _, page := range site.Pages() {
+		var elements []*Element
+
+		for _, element := range page.elements {
+			if element.csr {
+				elements = append(elements, element)
+			}
+		}
+
+		groups := groupByFramework(elements)
+		fmt.Fprintf(h, "page:%s\n", page.id)
+
+		for _, fw := range sortedFrameworks(groups) {
+			fmt.Fprintf(h, "%s\n%s\n", fw.name, fw.clientBundle(groups[fw]))
+		}
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+type metafile struct {
+	Inputs map[string]struct {
+		Imports []struct {
+			Path string `json:"path"`
+		} `json:"imports"`
+	} `json:"inputs"`
+	Outputs map[string]struct {
+		EntryPoint string              `json:"entryPoint"`
+		Inputs     map[string]struct{} `json:"inputs"`
+		Imports    []struct {
+			Path string `json:"path"`
+		} `json:"imports"`
+	} `json:"outputs"`
+}
+
+// readClientInputs records the files that went into each page's client
+// bundles, by following the imports of each entry point through esbuild's
+// metafile.
+func readClientInputs(site *Site, metafileJson string) error {
+	var meta metafile
+
+	if err := json.Unmarshal([]byte(metafileJson), &meta); err != nil {
+		return err
+	}
+
+	for _, output := range meta.Outputs {
+		if output.EntryPoint == "" {
+			continue
+		}
+
+		page := site.pageFromModule(output.EntryPoint)
+
+		if page == nil {
+			continue
+		}
+
+		inputs := map[string]bool{}
+		visited := map[string]bool{}
+		stack := []string{}
+
+		for _, imp := range output.Imports {
+			stack = append(stack, imp.Path)
+		}
+
+		for input := range output.Inputs {
+			if !strings.Contains(input, ":") {
+				inputs[path.Join(site.inputDir, input)] = true
+			}
+		}
+
+		for len(stack) > 0 {
+			end := len(stack) - 1
+			name := stack[end]
+			stack = stack[:end]
+			chunk, ok := meta.Outputs[name]
+
+			if visited[name] || !ok {
+				continue
+			}
+
+			visited[name] = true
+
+			for input := range chunk.Inputs {
+				if !strings.Contains(input, ":") {
+					inputs[path.Join(site.inputDir, input)] = true
+				}
+			}
+
+			for _, imp := range chunk.Imports {
+				stack = append(stack, imp.Path)
+			}
+		}
+
+		page.clientInputs = inputs
+	}
+
+	return nil
+}
+
+// islandUpdate tells the browser which elements to remount after a page's
+// client bundle has changed.
+type islandUpdate struct {
+	Src string   `json:"src"`
+	Ids []string `json:"ids"`
+}
+
+// islandUpdates works out whether the changed files can be hot replaced,
+// which is only possible when every change was to a module in the client
+// bundles. Elements that render a changed component are remounted, and if
+// the change was to one of their dependencies, then every element on the
+// page is remounted.
+func islandUpdates(site *Site, changed []string) ([]islandUpdate, bool) {
+	isInput := map[string]bool{}
+
+	for _, file := range changed {
+		if loader[path.Ext(file)] == api.LoaderFile {
+			return nil, false
+		}
+	}
+
+	var updates []islandUpdate
+
+	for _, page := range site.Pages() {
+		var hits []string
+
+		for _, file := range changed {
+			if page.clientInputs[file] {
+				hits = append(hits, file)
+				isInput[file] = true
+			}
+		}
+
+		if len(hits) == 0 {
+			continue
+		}
+
+		var ids, all []string
+
+		for _, element := range page.elements {
+			if !element.csr {
+				continue
+			}
+
+			all = append(all, element.id)
+			src := path.Join(element.dir, element.src)
+
+			for _, file := range hits {
+				if file == src {
+					ids = append(ids, element.id)
+				}
+			}
+		}
+
+		if len(ids) == 0 {
+			ids = all
+		}
+
+		sort.Strings(ids)
+
+		for _, src := range page.scripts {
+			updates = append(updates, islandUpdate{Src: src, Ids: ids})
+		}
+	}
+
+	if len(isInput) != len(changed) {
+		return nil, false
+	}
+
+	return updates, true
+}
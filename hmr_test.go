@@ -0,0 +1,37 @@
+package melange
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIslandUpdates(t *testing.T) {
+	p := &Page{id: "test", dir: "/site/pages", relPath: "/index.md"}
+	site := &Site{pages: map[string]*Page{p.id: p}}
+	a := p.addElement("./a.tsx", parseProps())
+	a.csr = true
+	b := p.addElement("./b.tsx", parseProps())
+	b.csr = true
+	p.scripts = []string{"/_assets/index.js"}
+	p.clientInputs = map[string]bool{
+		"/site/pages/a.tsx":    true,
+		"/site/pages/b.tsx":    true,
+		"/site/pages/utils.ts": true,
+	}
+
+	updates, ok := islandUpdates(site, []string{"/site/pages/a.tsx"})
+
+	if !ok || !reflect.DeepEqual(updates, []islandUpdate{{Src: "/_assets/index.js", Ids: []string{a.id}}}) {
+		t.Fatalf("expected only the changed component to be remounted: %v", updates)
+	}
+
+	updates, _ = islandUpdates(site, []string{"/site/pages/utils.ts"})
+
+	if len(updates) != 1 || len(updates[0].Ids) != 2 {
+		t.Fatalf("expected dependencies to remount every element on the page: %v", updates)
+	}
+
+	if _, ok := islandUpdates(site, []string{"/site/pages/index.md"}); ok {
+		t.Fatal("expected changes outside the client bundles to need a reload")
+	}
+}
@@ -0,0 +1,337 @@
+package melange
+
+import (
+	_ "embed"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hostRequest and hostReply are the messages exchanged with the host. Each
+// message is framed as a 4 byte big-endian length followed by JSON.
+type hostRequest struct {
+	Id   uint64 `json:"id"`
+	Path string `json:"path"`
+}
+
+type hostReply struct {
+	Id     uint64          `json:"id"`
+	Result *renderResponse `json:"result"`
+}
+
+// hostProcess is a long-running runtime process that renders static
+// bundles. It is started lazily, restarted if it crashes, and killed by
+// Close.
+type hostProcess struct {
+	runtime *jsRuntime
+	timeout time.Duration
+
+	mu      sync.Mutex
+	dir     string
+	cmd     *exec.Cmd
+	conn    net.Conn
+	exited  chan struct{}
+	stderr  *tailBuffer
+	nextId  uint64
+	pending map[uint64]chan *hostReply
+	// imported are the bundles that the process has imported, for runtimes
+	// that cache modules.
+	imported map[string]bool
+}
+
+func newHostProcess(runtime *jsRuntime) *hostProcess {
+	return &hostProcess{runtime: runtime, timeout: 30 * time.Second}
+}
+
+// start launches the runtime process and waits for it to connect. Each host
+// gets its own socket in a private temporary directory, so that concurrent
+// builds don't collide.
+func (host *hostProcess) start() error {
+	dir, err := os.MkdirTemp("", "melange-")
+
+	if err != nil {
+		return err
+	}
+
+	hostFile := path.Join(dir, "host.js")
+	sockAddr := path.Join(dir, "host.sock")
+
+	if err := os.WriteFile(hostFile, host.runtime.hostJs, 0600); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	listener, err := net.Listen("unix", sockAddr)
+
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	defer listener.Close()
+
+	stderr := &tailBuffer{limit: 4096}
+	cmd := exec.Command(host.runtime.command, host.runtime.args(hostFile, sockAddr)...)
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	exited := make(chan struct{})
+
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	listener.(*net.UnixListener).SetDeadline(time.Now().Add(host.timeout))
+	conn, err := listener.Accept()
+
+	if err != nil {
+		cmd.Process.Kill()
+		<-exited
+		os.RemoveAll(dir)
+		return fmt.Errorf("%s host didn't connect: %s %s", host.runtime.name, err, stderr)
+	}
+
+	host.dir = dir
+	host.cmd = cmd
+	host.conn = conn
+	host.exited = exited
+	host.stderr = stderr
+	host.pending = map[uint64]chan *hostReply{}
+	host.imported = map[string]bool{}
+
+	go host.readReplies(conn)
+	return nil
+}
+
+// readReplies dispatches replies to the requests that are waiting for them,
+// until the connection closes.
+func (host *hostProcess) readReplies(conn net.Conn) {
+	for {
+		var reply hostReply
+
+		if err := readFrame(conn, &reply); err != nil {
+			break
+		}
+
+		host.mu.Lock()
+		ch, ok := host.pending[reply.Id]
+		delete(host.pending, reply.Id)
+		host.mu.Unlock()
+
+		if ok {
+			ch <- &reply
+		}
+	}
+
+	// The host crashed or closed the connection, so clean up after it and
+	// fail any requests that are still waiting.
+	host.mu.Lock()
+	defer host.mu.Unlock()
+
+	if host.conn == conn {
+		host.stop()
+	}
+}
+
+// stop kills the runtime process and cleans up after it. The caller must hold
+// the lock.
+func (host *hostProcess) stop() {
+	if host.cmd == nil {
+		return
+	}
+
+	host.conn.Close()
+	host.cmd.Process.Kill()
+	<-host.exited
+	os.RemoveAll(host.dir)
+
+	for id, ch := range host.pending {
+		close(ch)
+		delete(host.pending, id)
+	}
+
+	host.cmd = nil
+	host.conn = nil
+}
+
+func (host *hostProcess) isRunning() bool {
+	if host.cmd == nil {
+		return false
+	}
+
+	select {
+	case <-host.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// render asks the host to render the elements in a static bundle.
+func (host *hostProcess) render(bundle string) (*renderResponse, error) {
+	host.mu.Lock()
+
+	// Bundles are rewritten in place by each build, and runtimes that cache
+	// modules would keep every version of them, so the process is replaced
+	// instead of importing a bundle again
+	stale := host.runtime.cachesModules && host.imported[bundle] && len(host.pending) == 0
+
+	if !host.isRunning() || stale {
+		// Clean up after a crashed or stale process before restarting
+		host.stop()
+
+		if err := host.start(); err != nil {
+			host.mu.Unlock()
+			return nil, err
+		}
+	}
+
+	host.imported[bundle] = true
+	host.nextId++
+	id := host.nextId
+	ch := make(chan *hostReply, 1)
+	host.pending[id] = ch
+	conn := host.conn
+	exited := host.exited
+	stderr := host.stderr
+	err := writeFrame(conn, hostRequest{Id: id, Path: bundle})
+	host.mu.Unlock()
+
+	if err != nil {
+		return nil, fmt.Errorf("%s host crashed: %s %s", host.runtime.name, err, stderr)
+	}
+
+	select {
+	case reply, ok := <-ch:
+		if !ok || reply.Result == nil {
+			return nil, fmt.Errorf("%s host crashed: %s", host.runtime.name, stderr)
+		}
+
+		return reply.Result, nil
+	case <-exited:
+		return nil, fmt.Errorf("%s host crashed: %s", host.runtime.name, stderr)
+	case <-time.After(host.timeout):
+		// The host is probably stuck, so it is restarted on the next request
+		host.mu.Lock()
+		host.stop()
+		host.mu.Unlock()
+		return nil, fmt.Errorf("%s host timed out after %s rendering %s", host.runtime.name, host.timeout, bundle)
+	}
+}
+
+// Close kills the runtime process, if it is running.
+func (host *hostProcess) Close() error {
+	host.mu.Lock()
+	defer host.mu.Unlock()
+	host.stop()
+	return nil
+}
+
+// hostPool is a fixed set of runtime processes that render static bundles in
+// parallel. Each host handles one bundle at a time.
+type hostPool struct {
+	hosts []*hostProcess
+	idle  chan *hostProcess
+}
+
+func newHostPool(runtime *jsRuntime, size int) *hostPool {
+	if size < 1 {
+		size = 1
+	}
+
+	pool := &hostPool{idle: make(chan *hostProcess, size)}
+
+	for i := 0; i < size; i++ {
+		host := newHostProcess(runtime)
+		pool.hosts = append(pool.hosts, host)
+		pool.idle <- host
+	}
+
+	return pool
+}
+
+// render waits for an idle host and renders the bundle with it. Hosts are
+// only started the first time they are used.
+func (pool *hostPool) render(bundle string) (*renderResponse, error) {
+	host := <-pool.idle
+	defer func() { pool.idle <- host }()
+	return host.render(bundle)
+}
+
+// Close stops every host in the pool.
+func (pool *hostPool) Close() error {
+	for _, host := range pool.hosts {
+		host.Close()
+	}
+
+	return nil
+}
+
+func writeFrame(w io.Writer, message any) error {
+	body, err := json.Marshal(message)
+
+	if err != nil {
+		return err
+	}
+
+	frame := make([]byte, 4+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(len(body)))
+	copy(frame[4:], body)
+	_, err = w.Write(frame)
+	return err
+}
+
+func readFrame(r io.Reader, message any) error {
+	var header [4]byte
+
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+
+	body := make([]byte, binary.BigEndian.Uint32(header[:]))
+
+	if _, err := io.ReadFull(r, body); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, message)
+}
+
+// tailBuffer keeps the last bytes written to it, so that a crashed
+// process's output can be included in errors.
+type tailBuffer struct {
+	mu    sync.Mutex
+	limit int
+	buf   []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+
+	if len(t.buf) > t.limit {
+		t.buf = t.buf[len(t.buf)-t.limit:]
+	}
+
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return strings.TrimSpace(string(t.buf))
+}
@@ -0,0 +1,280 @@
+package melange
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func requireNode(t *testing.T) {
+	requireRuntime(t, &nodeRuntime)
+}
+
+func requireRuntime(t *testing.T, runtime *jsRuntime) {
+	if _, err := exec.LookPath(runtime.command); err != nil {
+		t.Skipf("%s is not installed", runtime.name)
+	}
+}
+
+func writeBundle(t *testing.T, dir string, name string, contents string) string {
+	file := path.Join(dir, name)
+
+	if err := os.WriteFile(file, []byte(contents), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func TestFrames(t *testing.T) {
+	var buf bytes.Buffer
+	writeFrame(&buf, hostRequest{Id: 1, Path: "/a.js"})
+	writeFrame(&buf, hostRequest{Id: 2, Path: "/b.js"})
+
+	var a, b hostRequest
+
+	if err := readFrame(&buf, &a); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := readFrame(&buf, &b); err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Id != 1 || b.Path != "/b.js" {
+		t.Fatalf("unexpected frames %v %v", a, b)
+	}
+}
+
+func TestNodeHostRender(t *testing.T) {
+	requireNode(t)
+	dir := t.TempDir()
+	host := newHostProcess(&nodeRuntime)
+	defer host.Close()
+
+	bundle := writeBundle(t, dir, "ok.js", `
+		module.exports = {
+			a: () => "<p>a</p>",
+			b: async () => "<p>b</p>",
+			c: () => { throw new Error("broken") },
+		};
+	`)
+
+	response, err := host.render(bundle)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Html["a"] != "<p>a</p>" || response.Html["b"] != "<p>b</p>" {
+		t.Fatalf("unexpected html %v", response.Html)
+	}
+
+	if response.Errors["c"] == nil || response.Errors["c"].Message != "broken" {
+		t.Fatalf("expected errors to be reported per element: %v", response.Errors)
+	}
+}
+
+func TestNodeHostRestartsAfterCrash(t *testing.T) {
+	requireNode(t)
+	dir := t.TempDir()
+	host := newHostProcess(&nodeRuntime)
+	defer host.Close()
+
+	crash := writeBundle(t, dir, "crash.js", `module.exports = { a: () => process.exit(1) };`)
+	ok := writeBundle(t, dir, "ok.js", `module.exports = { a: () => "ok" };`)
+
+	if _, err := host.render(crash); err == nil || !strings.Contains(err.Error(), "crashed") {
+		t.Fatalf("expected crash to be detected, got %v", err)
+	}
+
+	response, err := host.render(ok)
+
+	if err != nil || response.Html["a"] != "ok" {
+		t.Fatalf("expected host to restart after a crash: %v", err)
+	}
+}
+
+func TestNodeHostTimeout(t *testing.T) {
+	requireNode(t)
+	dir := t.TempDir()
+	host := newHostProcess(&nodeRuntime)
+	host.timeout = 500 * time.Millisecond
+	defer host.Close()
+
+	hang := writeBundle(t, dir, "hang.js", `module.exports = { a: () => { while (true) {} } };`)
+
+	if _, err := host.render(hang); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected render to time out, got %v", err)
+	}
+}
+
+func TestNodeHostClose(t *testing.T) {
+	requireNode(t)
+	dir := t.TempDir()
+	host := newHostProcess(&nodeRuntime)
+	ok := writeBundle(t, dir, "ok.js", `module.exports = {};`)
+
+	if _, err := host.render(ok); err != nil {
+		t.Fatal(err)
+	}
+
+	tmp := host.dir
+	host.Close()
+
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Fatal("expected host's temp dir to be removed")
+	}
+
+	if host.isRunning() {
+		t.Fatal("expected host to be stopped")
+	}
+}
+
+func TestNodePoolRendersInParallel(t *testing.T) {
+	requireNode(t)
+	dir := t.TempDir()
+	pool := newHostPool(&nodeRuntime, 2)
+	defer pool.Close()
+
+	slow := writeBundle(t, dir, "slow.js", `
+		let start = Date.now();
+		while (Date.now() - start < 500) {}
+		module.exports = { a: () => "a" };
+	`)
+
+	// Warm up both hosts so that startup time isn't measured
+	pool.hosts[0].render(writeBundle(t, dir, "a.js", `module.exports = {};`))
+	pool.hosts[1].render(writeBundle(t, dir, "b.js", `module.exports = {};`))
+
+	start := time.Now()
+	done := make(chan error)
+
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := pool.render(slow)
+			done <- err
+		}()
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+		t.Fatalf("expected bundles to render in parallel, took %s", elapsed)
+	}
+}
+
+func TestHostRestartsToReimportCachedModules(t *testing.T) {
+	requireNode(t)
+	dir := t.TempDir()
+	runtime := nodeRuntime
+	runtime.cachesModules = true
+	host := newHostProcess(&runtime)
+	defer host.Close()
+
+	bundle := writeBundle(t, dir, "bundle.js", `module.exports = { a: () => "first" };`)
+	other := writeBundle(t, dir, "other.js", `module.exports = {};`)
+
+	if _, err := host.render(bundle); err != nil {
+		t.Fatal(err)
+	}
+
+	pid := host.cmd.Process.Pid
+
+	if _, err := host.render(other); err != nil || host.cmd.Process.Pid != pid {
+		t.Fatalf("expected host to import new bundles without restarting: %v", err)
+	}
+
+	writeBundle(t, dir, "bundle.js", `module.exports = { a: () => "second" };`)
+	response, err := host.render(bundle)
+
+	if err != nil || response.Html["a"] != "second" {
+		t.Fatalf("expected the rewritten bundle to render, got %v %v", response, err)
+	}
+
+	if host.cmd.Process.Pid == pid {
+		t.Fatal("expected host to restart before importing a bundle again")
+	}
+}
+
+func TestBunHostRender(t *testing.T) {
+	requireRuntime(t, &bunRuntime)
+	dir := t.TempDir()
+	host := newHostProcess(&bunRuntime)
+	defer host.Close()
+
+	bundle := writeBundle(t, dir, "ok.js", `
+		module.exports = {
+			a: async () => "<p>a</p>",
+			b: () => { throw new Error("broken") },
+		};
+	`)
+
+	response, err := host.render(bundle)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Html["a"] != "<p>a</p>" {
+		t.Fatalf("unexpected html %v", response.Html)
+	}
+
+	if response.Errors["b"] == nil || response.Errors["b"].Message != "broken" {
+		t.Fatalf("expected errors to be reported per element: %v", response.Errors)
+	}
+
+	writeBundle(t, dir, "ok.js", `module.exports = { a: () => "<p>changed</p>" };`)
+	response, err = host.render(bundle)
+
+	if err != nil || response.Html["a"] != "<p>changed</p>" {
+		t.Fatalf("expected the rewritten bundle to render, got %v %v", response, err)
+	}
+}
+
+func TestDenoHostRender(t *testing.T) {
+	requireRuntime(t, &denoRuntime)
+	dir := t.TempDir()
+	host := newHostProcess(&denoRuntime)
+	defer host.Close()
+
+	bundle := writeBundle(t, dir, "ok.js", `
+		export default {
+			a: async () => "<p>a</p>",
+			b: () => { throw new Error("broken") },
+		};
+	`)
+
+	response, err := host.render(bundle)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Html["a"] != "<p>a</p>" {
+		t.Fatalf("unexpected html %v", response.Html)
+	}
+
+	if response.Errors["b"] == nil || response.Errors["b"].Message != "broken" {
+		t.Fatalf("expected errors to be reported per element: %v", response.Errors)
+	}
+
+	if strings.Contains(response.Errors["b"].Stack, "file://") || !strings.Contains(response.Errors["b"].Stack, bundle) {
+		t.Fatalf("expected stack traces to use the bundle's path: %s", response.Errors["b"].Stack)
+	}
+
+	writeBundle(t, dir, "ok.js", `export default { a: () => "<p>changed</p>" };`)
+	response, err = host.render(bundle)
+
+	if err != nil || response.Html["a"] != "<p>changed</p>" {
+		t.Fatalf("expected the rewritten bundle to render, got %v %v", response, err)
+	}
+}
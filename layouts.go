@@ -0,0 +1,227 @@
+package melange
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template/parse"
+)
+
+// layout wraps the contents of pages. Layouts are html templates that
+// render the page's contents with {{ .Page.Contents | safeHTML }}, just like
+// themes.
+type layout struct {
+	path     string
+	template *htmltemplate.Template
+}
+
+// layoutNames are the names of the files that define a directory's layout.
+var layoutNames = []string{"_layout.html", "_layout.gohtml"}
+
+// loadLayouts parses the layout in each directory of the site, and the named
+// layouts in the "_layouts" directory.
+func loadLayouts(site *Site) error {
+	site.layouts = map[string]*layout{}
+	site.namedLayouts = map[string]*layout{}
+	dirs := []string{site.pagesDir}
+
+	for _, dir := range site.directories {
+		dirs = append(dirs, path.Join(site.pagesDir, dir))
+	}
+
+	for _, dir := range dirs {
+		for _, name := range layoutNames {
+			file := path.Join(dir, name)
+			l, err := readLayout(site, file)
+
+			if err != nil {
+				return err
+			}
+
+			if l != nil {
+				site.layouts[dir] = l
+				break
+			}
+		}
+	}
+
+	entries, err := os.ReadDir(path.Join(site.pagesDir, "_layouts"))
+
+	if err != nil {
+		return nil
+	}
+
+	for _, entry := range entries {
+		ext := path.Ext(entry.Name())
+
+		if entry.IsDir() || (ext != ".html" && ext != ".gohtml") {
+			continue
+		}
+
+		l, err := readLayout(site, path.Join(site.pagesDir, "_layouts", entry.Name()))
+
+		if err != nil {
+			return err
+		}
+
+		site.namedLayouts[strings.TrimSuffix(entry.Name(), ext)] = l
+	}
+
+	return nil
+}
+
+// readLayout parses a layout, returning nil if it doesn't exist.
+func readLayout(site *Site, file string) (*layout, error) {
+	html, err := os.ReadFile(file)
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	tpl, err := withHTMLPartials(site.partials.html, sharedFuncs(site.funcs, site.taxonomies)).New("layout").Parse(string(html))
+
+	if err != nil {
+		return nil, newTemplateError(TemplateParseError, file, err)
+	}
+
+	if err := checkContentsEscaping(tpl, file); err != nil {
+		return nil, err
+	}
+
+	return &layout{path: file, template: tpl}, nil
+}
+
+// checkContentsEscaping reports themes and layouts that print the page's
+// contents without safeHTML. Their contents are HTML, so html/template would
+// escape the whole page, which was how themes worked before they were html
+// templates.
+func checkContentsEscaping(tpl *htmltemplate.Template, file string) error {
+	var printed parse.Node
+	var tree *parse.Tree
+
+	for _, t := range tpl.Templates() {
+		// Only check the templates that were defined in this file, not partials
+		if t.Tree == nil || t.Tree.ParseName != tpl.Tree.ParseName {
+			continue
+		}
+
+		if treesMatch([]*parse.Tree{t.Tree}, printsContents(&printed)) {
+			tree = t.Tree
+			break
+		}
+	}
+
+	if printed == nil {
+		return nil
+	}
+
+	err := &BuildError{
+		Category: TemplateParseError,
+		File:     file,
+		Message:  "the page's contents are escaped unless they're printed with {{ .Page.Contents | safeHTML }}",
+	}
+
+	location, _ := tree.ErrorContext(printed)
+	parts := strings.Split(location, ":")
+
+	if len(parts) >= 3 {
+		err.Line, _ = strconv.Atoi(parts[len(parts)-2])
+		err.Column, _ = strconv.Atoi(parts[len(parts)-1])
+	}
+
+	err.Frame = codeFrame(file, err.Line, err.Column)
+	return err
+}
+
+// printsContents matches actions that print a field named Contents directly,
+// like {{ .Page.Contents }}, and stores the action in printed.
+func printsContents(printed *parse.Node) func(parse.Node) bool {
+	return func(node parse.Node) bool {
+		action, ok := node.(*parse.ActionNode)
+
+		if !ok || action.Pipe == nil || len(action.Pipe.Decl) > 0 || len(action.Pipe.Cmds) != 1 {
+			return false
+		}
+
+		if args := action.Pipe.Cmds[0].Args; len(args) != 1 || !isField("Contents")(args[0]) {
+			return false
+		}
+
+		*printed = action
+		return true
+	}
+}
+
+// layoutsFor returns the layouts that wrap a page, from the innermost out.
+// A page with a "layout" in its front matter uses that named layout.
+// Otherwise it uses the layout from its own directory, nested inside the
+// layouts of each of its parent directories.
+func (site *Site) layoutsFor(page *Page) ([]*layout, error) {
+	if name, ok := page.Data["layout"]; ok {
+		l := site.namedLayouts[fmt.Sprint(name)]
+
+		if l == nil {
+			return nil, &BuildError{
+				Category: TemplateExecError,
+				File:     page.absPath,
+				Message:  fmt.Sprintf("unknown layout %q, expected one of %s", name, strings.Join(site.layoutNames(), ", ")),
+			}
+		}
+
+		return []*layout{l}, nil
+	}
+
+	var layouts []*layout
+
+	for dir := page.dir; strings.HasPrefix(dir, site.pagesDir); dir = path.Dir(dir) {
+		if l := site.layouts[dir]; l != nil {
+			layouts = append(layouts, l)
+		}
+
+		if dir == site.pagesDir {
+			break
+		}
+	}
+
+	return layouts, nil
+}
+
+// layoutNames returns the names of the named layouts.
+func (site *Site) layoutNames() []string {
+	var names []string
+
+	for name := range site.namedLayouts {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// applyLayouts renders a page's contents into each of its layouts in turn.
+func applyLayouts(page *Page, site *Site, scope renderContext) error {
+	layouts, err := site.layoutsFor(page)
+
+	if err != nil {
+		return err
+	}
+
+	for _, l := range layouts {
+		var buf bytes.Buffer
+
+		if err := l.template.Execute(&buf, scope); err != nil {
+			return newPartialAwareError(site, TemplateExecError, l.path, err)
+		}
+
+		page.Contents = buf.String()
+	}
+
+	return nil
+}
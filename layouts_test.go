@@ -0,0 +1,104 @@
+package melange
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestLayouts(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"pages/_theme.html":           "<theme>{{ .Page.Contents | safeHTML }}</theme>",
+		"pages/_layout.html":          "<root>{{ .Page.Contents | safeHTML }}</root>",
+		"pages/blog/_layout.html":     "<blog>{{ .Page.Contents | safeHTML }}</blog>",
+		"pages/_layouts/landing.html": "<landing>{{ .Page.Contents | safeHTML }}</landing>",
+		"pages/index.md":              "---\nlayout: landing\n---\nhome",
+		"pages/about.md":              "about",
+		"pages/blog/posts/first.md":   "first",
+	})
+
+	if _, err := Build(BuildOptions{Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"_site/index.html":            "<theme><landing><p>home</p>\n</landing></theme>",
+		"_site/about.html":            "<theme><root><p>about</p>\n</root></theme>",
+		"_site/blog/posts/first.html": "<theme><root><blog><p>first</p>\n</blog></root></theme>",
+	}
+
+	for file, expected := range tests {
+		html, _ := os.ReadFile(path.Join(dir, file))
+
+		if string(html) != expected {
+			t.Fatalf("expected %s to be %q, got %q", file, expected, html)
+		}
+	}
+}
+
+func TestUnknownLayout(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"pages/_layouts/post.html": "{{ .Page.Contents | safeHTML }}",
+		"pages/index.md":           "---\nlayout: landing\n---\n",
+	})
+
+	_, err := Build(BuildOptions{Dir: dir})
+
+	if err == nil || !strings.Contains(err.Error(), `unknown layout "landing", expected one of post`) {
+		t.Fatalf("expected unknown layout error, got %v", err)
+	}
+}
+
+func TestLayoutParseError(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"pages/blog/_layout.html": "{{ .Page.Contents",
+		"pages/blog/post.md":      "",
+	})
+
+	_, err := Build(BuildOptions{Dir: dir})
+	errs := AsBuildErrors(err)
+
+	if len(errs) != 1 || errs[0].Category != TemplateParseError || !strings.HasSuffix(errs[0].File, "blog/_layout.html") {
+		t.Fatalf("expected parse error in layout, got %v", err)
+	}
+}
+
+func TestUnescapedContents(t *testing.T) {
+	tests := map[string]string{
+		"pages/_theme.html":        "<main>\n  {{ .Page.Contents }}\n</main>",
+		"pages/_layout.html":       "{{ with .Page }}\n<div>{{ .Contents }}</div>{{ end }}",
+		"pages/_layouts/post.html": "{{ define \"body\" }}\n\n{{ $.Page.Contents }}{{ end }}{{ template \"body\" . }}",
+		"pages/_taxonomy.html":     "<p>\n{{ .Page.Contents }}</p>",
+	}
+
+	lines := map[string]int{
+		"pages/_theme.html":        2,
+		"pages/_layout.html":       2,
+		"pages/_layouts/post.html": 3,
+		"pages/_taxonomy.html":     2,
+	}
+
+	for file, contents := range tests {
+		dir := createTestSite(t, map[string]string{
+			file:             contents,
+			"pages/index.md": "",
+		})
+
+		_, err := Build(BuildOptions{Dir: dir})
+		errs := AsBuildErrors(err)
+
+		if len(errs) != 1 || !strings.HasSuffix(errs[0].File, file) || errs[0].Line != lines[file] || !strings.Contains(errs[0].Message, "safeHTML") {
+			t.Fatalf("expected %s to fail on line %d for printing contents without safeHTML, got %v", file, lines[file], err)
+		}
+	}
+
+	dir := createTestSite(t, map[string]string{
+		"pages/_theme.html": `{{ $contents := .Page.Contents }}{{ len .Page.Contents }}{{ $contents | safeHTML }}`,
+		"pages/index.md":    "",
+	})
+
+	if _, err := Build(BuildOptions{Dir: dir}); err != nil {
+		t.Fatalf("expected contents that aren't printed directly to be allowed, got %v", err)
+	}
+}
@@ -1,12 +1,74 @@
 let net = require("net");
-let sockAddr = "/tmp/melange.sock";
+let [sockAddr] = process.argv.slice(2);
 
 let client = net.createConnection(sockAddr);
 
-client.on("data", data => {
-  let requirePath = data.toString();
-  delete require.cache[requirePath];
-  let value = require(requirePath);
-  let json = JSON.stringify(value);
-  client.write(json);
+function serializeError(err) {
+  if (err instanceof Error) {
+    return { message: err.message, stack: err.stack || "" };
+  } else {
+    return { message: String(err), stack: "" };
+  }
+}
+
+// Each element is rendered separately, so that one broken component can't
+// stop the others from rendering, and so that errors can be traced back to
+// the element that caused them.
+async function renderElements(requirePath) {
+  let response = { html: {}, errors: {} };
+
+  try {
+    delete require.cache[requirePath];
+    let elements = require(requirePath);
+
+    await Promise.all(
+      Object.entries(elements).map(async ([id, render]) => {
+        try {
+          // Some frameworks render asynchronously
+          response.html[id] = await render();
+        } catch (err) {
+          response.errors[id] = serializeError(err);
+        }
+      })
+    );
+  } catch (err) {
+    response.error = serializeError(err);
+  }
+
+  return response;
+}
+
+// Messages are framed as a 4 byte big-endian length followed by JSON.
+function send(message) {
+  let body = Buffer.from(JSON.stringify(message));
+  let header = Buffer.alloc(4);
+  header.writeUInt32BE(body.length);
+  client.write(Buffer.concat([header, body]));
+}
+
+async function handle(request) {
+  let result = await renderElements(request.path);
+  send({ id: request.id, result });
+}
+
+let buffer = Buffer.alloc(0);
+
+client.on("data", chunk => {
+  buffer = Buffer.concat([buffer, chunk]);
+
+  while (buffer.length >= 4) {
+    let length = buffer.readUInt32BE(0);
+
+    if (buffer.length < 4 + length) {
+      break;
+    }
+
+    let request = JSON.parse(buffer.subarray(4, 4 + length).toString());
+    buffer = buffer.subarray(4 + length);
+    handle(request);
+  }
 });
+
+// Exit with the process that started us
+client.on("close", () => process.exit(0));
+client.on("error", () => process.exit(1));
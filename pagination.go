@@ -0,0 +1,140 @@
+package melange
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Paginator is one page of a list of pages that was split up with the
+// paginate func. The first page is rendered at the page's own url, and the
+// rest at "page/2/", "page/3/" and so on, beneath it.
+type Paginator struct {
+	// Number is the number of this page, starting from 1.
+	Number int
+	// Total is the number of pages.
+	Total int
+	// Pages are the items on this page.
+	Pages []*Page
+	// Prev and Next are the urls of the neighbouring pages, which are empty
+	// on the first and last pages.
+	Prev string
+	Next string
+
+	urls []string
+}
+
+// Url returns the url of one of the pages, by its number.
+func (pager *Paginator) Url(number int) string {
+	if number < 1 || number > len(pager.urls) {
+		return ""
+	}
+
+	return pager.urls[number-1]
+}
+
+// paginate splits items into pages of size, and returns the page that p is
+// currently rendering.
+func paginate(p *Page, items []*Page, size int) (*Paginator, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("paginate needs a page size of at least 1, got %d", size)
+	}
+
+	if p.paginator != nil {
+		return nil, fmt.Errorf("paginate can only be called once per page")
+	}
+
+	number := p.pageNumber
+
+	if number < 1 {
+		number = 1
+	}
+
+	total := (len(items) + size - 1) / size
+
+	if total < 1 {
+		total = 1
+	}
+
+	pager := &Paginator{Number: number, Total: total}
+	first := p
+
+	if p.source != nil {
+		first = p.source
+	}
+
+	for n := 1; n <= total; n++ {
+		if n == 1 {
+			pager.urls = append(pager.urls, first.Url)
+		} else {
+			pager.urls = append(pager.urls, pageUrl(paginatedPath(first.relPath, n)))
+		}
+	}
+
+	start := (number - 1) * size
+	end := start + size
+
+	if start > len(items) {
+		start = len(items)
+	}
+
+	if end > len(items) {
+		end = len(items)
+	}
+
+	pager.Pages = items[start:end]
+	pager.Prev = pager.Url(number - 1)
+	pager.Next = pager.Url(number + 1)
+	p.paginator = pager
+	return pager, nil
+}
+
+// paginatedPath is the path of one of the pages of a paginated page. The
+// pages are nested beneath the page's directory, or beneath a directory with
+// the page's name.
+func paginatedPath(relPath string, number int) string {
+	base := strings.TrimSuffix(relPath, path.Ext(relPath))
+	base = strings.TrimSuffix(base, "/index")
+	return path.Join("/", base, "page", fmt.Sprint(number), "index.html")
+}
+
+// addPaginatedPages adds the rest of the pages for each of the pages that
+// called paginate. They're read from the same source, and only differ in
+// their page number.
+func addPaginatedPages(site *Site, pages []*Page) ([]*Page, error) {
+	var added []*Page
+
+	for _, page := range pages {
+		if page.paginator == nil || page.source != nil {
+			continue
+		}
+
+		for n := 2; n <= page.paginator.Total; n++ {
+			relPath := paginatedPath(page.relPath, n)
+			id := shortHash(relPath)
+
+			next := &Page{
+				id:         id,
+				outputDir:  page.outputDir,
+				dir:        page.dir,
+				depth:      page.depth,
+				absPath:    page.absPath,
+				relPath:    relPath,
+				Name:       page.Name,
+				Url:        pageUrl(relPath),
+				pageNumber: n,
+				source:     page,
+			}
+
+			if err := readPage(next, site); err != nil {
+				return nil, err
+			}
+
+			site.pages[id] = next
+			site.directories = append(site.directories, path.Dir(relPath))
+			added = append(added, next)
+		}
+	}
+
+	return added, nil
+}
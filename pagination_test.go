@@ -0,0 +1,93 @@
+package melange
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestPaginate(t *testing.T) {
+	files := map[string]string{
+		"pages/_theme.html":   `{{ with .Paginator }}{{ .Number }}/{{ .Total }} prev={{ .Prev }} next={{ .Next }} {{ end }}{{ .Page.Contents | safeHTML }}`,
+		"pages/blog/index.md": `{{ $p := paginate pages 2 }}{{ range $p.Pages }}{{ .Title }} {{ end }}`,
+		"pages/archive.md":    `{{ range (paginate pages 10).Pages }}{{ .Title }} {{ end }}`,
+		"pages/index.md":      `{{ range pages }}{{ .Url }} {{ end }}`,
+	}
+
+	for i := 1; i <= 5; i++ {
+		files[fmt.Sprintf("pages/blog/post-%d.md", i)] = fmt.Sprintf("# Post %d", i)
+	}
+
+	dir := createTestSite(t, files)
+
+	if _, err := Build(BuildOptions{Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"_site/blog/index.html":        "1/3 prev= next=/blog/page/2/ <p>Post 1 Post 2</p>\n",
+		"_site/blog/page/2/index.html": "2/3 prev=/blog/ next=/blog/page/3/ <p>Post 3 Post 4</p>\n",
+		"_site/blog/page/3/index.html": "3/3 prev=/blog/page/2/ next= <p>Post 5</p>\n",
+		"_site/archive.html":           "1/1 prev= next= <p>archive index</p>\n",
+	}
+
+	for file, contents := range expected {
+		html, err := os.ReadFile(path.Join(dir, file))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(html) != contents {
+			t.Fatalf("expected %s to be %q, got %q", file, contents, html)
+		}
+	}
+
+	index, _ := os.ReadFile(path.Join(dir, "_site/index.html"))
+
+	if strings.Contains(string(index), "/page/") {
+		t.Fatalf("expected paginated pages not to be listed, got %q", index)
+	}
+}
+
+func TestPaginatedPagesAreRemoved(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"pages/index.md": `{{ range (paginate pages 1).Pages }}{{ .Title }}{{ end }}`,
+		"pages/a.md":     "a",
+		"pages/b.md":     "b",
+	})
+
+	if _, err := Build(BuildOptions{Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path.Join(dir, "_site/page/2/index.html")); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Remove(path.Join(dir, "pages/b.md"))
+
+	if _, err := Build(BuildOptions{Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path.Join(dir, "_site/page/2/index.html")); !os.IsNotExist(err) {
+		t.Fatal("expected the second page to be removed")
+	}
+}
+
+func TestPaginatedPath(t *testing.T) {
+	tests := map[string]string{
+		"/index.md":      "/page/2/index.html",
+		"/blog/index.md": "/blog/page/2/index.html",
+		"/archive.md":    "/archive/page/2/index.html",
+	}
+
+	for relPath, expected := range tests {
+		if actual := paginatedPath(relPath, 2); actual != expected {
+			t.Fatalf("expected %s to be paginated at %s, got %s", relPath, expected, actual)
+		}
+	}
+}
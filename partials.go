@@ -0,0 +1,218 @@
+package melange
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// partial is a template in the "_partials" directory. Partials are named
+// by their path within the directory, without the extension.
+type partial struct {
+	name   string
+	file   string
+	source string
+}
+
+// partials are parsed into two sets: a text set for markdown pages, and an
+// html set for layouts and themes. Pages, layouts and themes are parsed into
+// a copy of these sets, so that they can call partials with
+// {{ template "card" . }} or {{ partial "card" "title" .Page.Title }}.
+type partials struct {
+	text  *template.Template
+	html  *htmltemplate.Template
+	files map[string]string
+}
+
+// loadPartials reads and parses the templates in the "_partials" directory.
+// Partials can use any of the funcs that the templates that call them can,
+// so they're parsed with stand-ins that are replaced by each page.
+func loadPartials(pagesDir string, funcs template.FuncMap) (*partials, error) {
+	sources, err := readPartials(path.Join(pagesDir, "_partials"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	set := &partials{
+		text:  withTextPartials(template.New("_partials"), sharedFuncs(funcs, nil)),
+		html:  withHTMLPartials(htmltemplate.New("_partials"), sharedFuncs(funcs, nil)),
+		files: map[string]string{},
+	}
+
+	for _, p := range sources {
+		if _, err := set.text.New(p.name).Parse(p.source); err != nil {
+			return nil, newTemplateError(TemplateParseError, p.file, err)
+		}
+
+		if _, err := set.html.New(p.name).Parse(p.source); err != nil {
+			return nil, newTemplateError(TemplateParseError, p.file, err)
+		}
+
+		set.files[p.name] = p.file
+	}
+
+	return set, nil
+}
+
+func readPartials(dir string) ([]partial, error) {
+	var sources []partial
+
+	err := filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return fs.SkipDir
+		} else if err != nil {
+			return err
+		}
+
+		ext := path.Ext(file)
+
+		if entry.IsDir() || (ext != ".html" && ext != ".gohtml") {
+			return nil
+		}
+
+		contents, err := os.ReadFile(file)
+
+		if err != nil {
+			return err
+		}
+
+		sources = append(sources, partial{
+			name:   strings.TrimSuffix(strings.TrimPrefix(file, dir+"/"), ext),
+			file:   file,
+			source: string(contents),
+		})
+
+		return nil
+	})
+
+	return sources, err
+}
+
+// withTextPartials returns a copy of a set of partials with funcs added, for
+// a page to be parsed into. The "partial" func executes partials from the
+// copy, so that they can use the funcs of the page that calls them.
+func withTextPartials(partials *template.Template, funcs template.FuncMap) *template.Template {
+	set := template.Must(partials.Clone())
+	templateFuncs := template.FuncMap{}
+
+	for name, fn := range funcs {
+		templateFuncs[name] = fn
+	}
+
+	for name, fn := range collectionFuncs {
+		templateFuncs[name] = fn
+	}
+
+	templateFuncs["dict"] = dict
+	templateFuncs["safeHTML"] = fmt.Sprint
+	templateFuncs["partial"] = func(name string, args ...any) (string, error) {
+		var buf bytes.Buffer
+		err := set.ExecuteTemplate(&buf, name, partialData(args))
+		return buf.String(), err
+	}
+
+	return set.Funcs(templateFuncs)
+}
+
+// withHTMLPartials is like withTextPartials, for layouts and themes. The
+// output of partials has already been escaped, so it's marked as safe.
+func withHTMLPartials(partials *htmltemplate.Template, funcs template.FuncMap) *htmltemplate.Template {
+	set := htmltemplate.Must(partials.Clone())
+	templateFuncs := htmltemplate.FuncMap{}
+
+	for name, fn := range funcs {
+		templateFuncs[name] = fn
+	}
+
+	for name, fn := range collectionFuncs {
+		templateFuncs[name] = fn
+	}
+
+	templateFuncs["dict"] = dict
+	templateFuncs["safeHTML"] = safeHTML
+	templateFuncs["partial"] = func(name string, args ...any) (htmltemplate.HTML, error) {
+		var buf bytes.Buffer
+		err := set.ExecuteTemplate(&buf, name, partialData(args))
+		return htmltemplate.HTML(buf.String()), err
+	}
+
+	return set.Funcs(templateFuncs)
+}
+
+// partialData is the value that a partial is executed with. A single
+// argument is passed as it is, otherwise the arguments are pairs of keys and
+// values.
+func partialData(args []any) any {
+	if len(args) == 1 {
+		return args[0]
+	}
+
+	return dict(args...)
+}
+
+// sharedFuncs returns the funcs for templates that are shared between pages,
+// such as themes and layouts. Funcs that only make sense inside a page fail
+// when they're called.
+func sharedFuncs(funcs template.FuncMap, taxonomies *taxonomyIndex) template.FuncMap {
+	templateFuncs := template.FuncMap{}
+
+	for name := range pageFuncs(nil, &Site{}) {
+		name := name
+		templateFuncs[name] = func(...any) (any, error) {
+			return nil, fmt.Errorf("%s can only be used in pages", name)
+		}
+	}
+
+	for name, fn := range funcs {
+		if _, ok := templateFuncs[name]; !ok {
+			templateFuncs[name] = fn
+		}
+	}
+
+	// Taxonomies are the same for every page, so they can be used anywhere
+	templateFuncs["taxonomy"] = func(name string) ([]*Term, error) {
+		return taxonomies.terms(name)
+	}
+
+	return templateFuncs
+}
+
+// dict makes a map from pairs of keys and values, for passing multiple values
+// to a partial.
+func dict(kvs ...any) map[string]any {
+	m := map[string]any{}
+
+	for i := 0; i+1 < len(kvs); i += 2 {
+		m[fmt.Sprint(kvs[i])] = kvs[i+1]
+	}
+
+	return m
+}
+
+// safeHTML marks a value as HTML that shouldn't be escaped, such as a page's
+// contents, or an element's marker.
+func safeHTML(value any) htmltemplate.HTML {
+	return htmltemplate.HTML(fmt.Sprint(value))
+}
+
+var templateNameRegex = regexp.MustCompile(`^(?:html/)?template: ?([^:]+):`)
+
+// newPartialAwareError is like newTemplateError, but reports errors from
+// inside partials in the partial's file.
+func newPartialAwareError(site *Site, category ErrorCategory, file string, err error) *BuildError {
+	if match := templateNameRegex.FindStringSubmatch(err.Error()); match != nil {
+		if partialFile, ok := site.partials.files[match[1]]; ok {
+			file = partialFile
+		}
+	}
+
+	return newTemplateError(category, file, err)
+}
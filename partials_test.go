@@ -0,0 +1,69 @@
+package melange
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestPartials(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"pages/_partials/card.html":        `<card>{{ .title }}</card>`,
+		"pages/_partials/forms/email.html": `<form>{{ . }}</form>`,
+		"pages/_theme.html":                `{{ template "forms/email" "theme" }}{{ .Page.Contents | safeHTML }}`,
+		"pages/_layout.html":               `{{ partial "card" "title" "layout" }}{{ .Page.Contents | safeHTML }}`,
+		"pages/index.md":                   `{{ template "card" (dict "title" "page") }}{{ partial "forms/email" "page" }}`,
+	})
+
+	if _, err := Build(BuildOptions{Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	html, _ := os.ReadFile(path.Join(dir, "_site/index.html"))
+	expected := "<form>theme</form><card>layout</card><p><card>page</card><form>page</form></p>\n"
+
+	if string(html) != expected {
+		t.Fatalf("expected %q, got %q", expected, html)
+	}
+}
+
+func TestPartialErrors(t *testing.T) {
+	tests := []struct {
+		files    map[string]string
+		category ErrorCategory
+		file     string
+		message  string
+	}{
+		{
+			files:    map[string]string{"pages/_partials/card.html": "{{ .title", "pages/index.md": ""},
+			category: TemplateParseError,
+			file:     "pages/_partials/card.html",
+		},
+		{
+			files:    map[string]string{"pages/_partials/card.html": "{{ .title.x }}", "pages/index.md": `{{ template "card" (dict "title" 1) }}`},
+			category: TemplateExecError,
+			file:     "pages/_partials/card.html",
+		},
+		{
+			files:    map[string]string{"pages/_theme.html": `{{ render "./_counter.js" }}`, "pages/index.md": ""},
+			category: TemplateExecError,
+			file:     "pages/_theme.html",
+			message:  "render can only be used in pages",
+		},
+	}
+
+	for _, test := range tests {
+		dir := createTestSite(t, test.files)
+		_, err := Build(BuildOptions{Dir: dir})
+		errs := AsBuildErrors(err)
+
+		if len(errs) != 1 || errs[0].Category != test.category || errs[0].File != path.Join(dir, test.file) {
+			t.Fatalf("expected %s error in %s, got %v", test.category, test.file, err)
+		}
+
+		if !strings.Contains(errs[0].Message, test.message) {
+			t.Fatalf("expected error to contain %q, got %q", test.message, errs[0].Message)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package melange
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// preflight checks that the runtimes and packages that the site's elements
+// need are installed before anything is bundled, so that missing ones are
+// reported with instructions for installing them, rather than as obscure
+// bundler or socket errors.
+func preflight(site *Site) error {
+	var elements []*Element
+	ssr := false
+
+	for _, page := range site.Pages() {
+		for _, element := range page.elements {
+			elements = append(elements, element)
+			ssr = ssr || element.ssr
+		}
+	}
+
+	if len(elements) == 0 {
+		return nil
+	}
+
+	var runtimes []*jsRuntime
+	var packages []string
+	compiled := false
+
+	if ssr {
+		runtimes = append(runtimes, site.runtime)
+	}
+
+	for _, fw := range sortedFrameworks(groupByFramework(elements)) {
+		packages = append(packages, fw.staticExternal...)
+		packages = append(packages, fw.compilerPackages...)
+		compiled = compiled || fw.plugin != nil
+	}
+
+	// Compilers always run with node, whichever runtime renders the site
+	if compiled && !(ssr && site.runtime == &nodeRuntime) {
+		runtimes = append(runtimes, &nodeRuntime)
+	}
+
+	var errs BuildErrors
+
+	for _, runtime := range runtimes {
+		if err := checkRuntime(runtime); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if missing := missingPackages(site.inputDir, packages); len(missing) > 0 {
+		errs = append(errs, &BuildError{
+			Category: PreflightError,
+			Message: fmt.Sprintf(
+				"missing packages %s, install them with:\n\n  %s\n",
+				strings.Join(missing, ", "),
+				installCommand(site.inputDir, missing),
+			),
+		})
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+var versionRegex = regexp.MustCompile(`(\d+)\.\d+`)
+
+// versionChecks remembers the result of checking each installed runtime's
+// version, so that the runtime isn't started again for every rebuild.
+var versionChecks = struct {
+	sync.Mutex
+	results map[*jsRuntime]*BuildError
+}{results: map[*jsRuntime]*BuildError{}}
+
+// checkRuntime checks that a runtime is installed and recent enough.
+func checkRuntime(runtime *jsRuntime) *BuildError {
+	if runtime.command == "" {
+		return nil
+	}
+
+	// Missing runtimes are looked for again, in case they've been installed
+	if _, err := exec.LookPath(runtime.command); err != nil {
+		return &BuildError{
+			Category: PreflightError,
+			Message:  fmt.Sprintf("%s is not installed, see %s", runtime.command, runtime.install),
+		}
+	}
+
+	versionChecks.Lock()
+	defer versionChecks.Unlock()
+
+	if result, ok := versionChecks.results[runtime]; ok {
+		return result
+	}
+
+	result := checkVersion(runtime)
+	versionChecks.results[runtime] = result
+	return result
+}
+
+// checkVersion checks that an installed runtime is recent enough.
+func checkVersion(runtime *jsRuntime) *BuildError {
+	out, err := exec.Command(runtime.command, "--version").Output()
+
+	if err != nil {
+		return &BuildError{
+			Category: PreflightError,
+			Message:  fmt.Sprintf("couldn't check the version of %s: %s", runtime.command, err),
+		}
+	}
+
+	match := versionRegex.FindStringSubmatch(string(out))
+
+	if match == nil {
+		return nil
+	}
+
+	if major, _ := strconv.Atoi(match[1]); major < runtime.minVersion {
+		return &BuildError{
+			Category: PreflightError,
+			Message: fmt.Sprintf(
+				"%s %s is too old, melange needs version %d or later, see %s",
+				runtime.command,
+				strings.TrimSpace(string(out)),
+				runtime.minVersion,
+				runtime.install,
+			),
+		}
+	}
+
+	return nil
+}
+
+// missingPackages returns the packages that can't be resolved from dir,
+// following node's resolution rules.
+func missingPackages(dir string, packages []string) []string {
+	var missing []string
+	seen := map[string]bool{}
+
+	for _, name := range packages {
+		name = packageName(name)
+
+		if seen[name] {
+			continue
+		}
+
+		seen[name] = true
+
+		if !resolvePackage(dir, name) {
+			missing = append(missing, name)
+		}
+	}
+
+	return missing
+}
+
+// packageName strips the subpath from an import, so that "react-dom/server"
+// becomes "react-dom", and "@vue/compiler-sfc/x" becomes "@vue/compiler-sfc".
+func packageName(specifier string) string {
+	parts := strings.Split(specifier, "/")
+
+	if strings.HasPrefix(specifier, "@") && len(parts) > 1 {
+		return strings.Join(parts[:2], "/")
+	}
+
+	return parts[0]
+}
+
+func resolvePackage(dir string, name string) bool {
+	for {
+		if _, err := os.Stat(path.Join(dir, "node_modules", name, "package.json")); err == nil {
+			return true
+		}
+
+		parent := path.Dir(dir)
+
+		if parent == dir {
+			return false
+		}
+
+		dir = parent
+	}
+}
+
+// installCommand returns the command that installs packages with the
+// package manager that the site uses, based on its lockfile.
+func installCommand(dir string, packages []string) string {
+	command := "npm install"
+	lockfiles := []struct{ file, command string }{
+		{"pnpm-lock.yaml", "pnpm add"},
+		{"yarn.lock", "yarn add"},
+		{"bun.lockb", "bun add"},
+	}
+
+	for _, lockfile := range lockfiles {
+		if _, err := os.Stat(path.Join(dir, lockfile.file)); err == nil {
+			command = lockfile.command
+			break
+		}
+	}
+
+	return fmt.Sprintf("%s %s", command, strings.Join(packages, " "))
+}
@@ -0,0 +1,98 @@
+package melange
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestPackageName(t *testing.T) {
+	tests := map[string]string{
+		"preact":               "preact",
+		"react-dom/server":     "react-dom",
+		"@vue/compiler-sfc":    "@vue/compiler-sfc",
+		"@vue/compiler-sfc/x":  "@vue/compiler-sfc",
+		"solid-js/web/dist/ok": "solid-js",
+	}
+
+	for specifier, expected := range tests {
+		if actual := packageName(specifier); actual != expected {
+			t.Fatalf("expected %q to be %q, got %q", specifier, expected, actual)
+		}
+	}
+}
+
+func TestMissingPackages(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"node_modules/preact/package.json": "{}",
+		"site/pages/index.md":              "",
+	})
+
+	missing := missingPackages(dir+"/site", []string{"preact", "preact-render-to-string", "react-dom/server", "react-dom/client"})
+
+	if strings.Join(missing, " ") != "preact-render-to-string react-dom" {
+		t.Fatalf("expected packages to resolve from parent directories, got %v", missing)
+	}
+}
+
+func TestInstallCommand(t *testing.T) {
+	npm := createTestSite(t, map[string]string{})
+	yarn := createTestSite(t, map[string]string{"yarn.lock": ""})
+
+	if command := installCommand(npm, []string{"preact", "preact-render-to-string"}); command != "npm install preact preact-render-to-string" {
+		t.Fatalf("unexpected command %q", command)
+	}
+
+	if command := installCommand(yarn, []string{"preact"}); command != "yarn add preact" {
+		t.Fatalf("unexpected command %q", command)
+	}
+}
+
+func TestCheckRuntime(t *testing.T) {
+	missing := jsRuntime{name: "missing", command: "melange-missing-runtime", install: "https://example.com"}
+
+	if err := checkRuntime(&missing); err == nil || !strings.Contains(err.Message, "not installed") {
+		t.Fatalf("expected missing runtime to be reported, got %v", err)
+	}
+
+	if err := checkRuntime(&gojaRuntime); err != nil {
+		t.Fatalf("expected embedded runtimes to pass, got %v", err)
+	}
+}
+
+func TestBuildMissingPackages(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"pages/index.md":    `{{ render "./_counter.js" }}`,
+		"pages/_counter.js": `export default () => null;`,
+	})
+
+	_, err := Build(BuildOptions{Dir: dir})
+	errs := AsBuildErrors(err)
+
+	if len(errs) == 0 || errs[len(errs)-1].Category != PreflightError {
+		t.Fatalf("expected preflight error, got %v", err)
+	}
+
+	if !strings.Contains(err.Error(), "npm install preact preact-render-to-string") {
+		t.Fatalf("expected install command, got %v", err)
+	}
+}
+
+func TestCheckRuntimeCachesVersion(t *testing.T) {
+	dir := t.TempDir()
+	counter := path.Join(dir, "count")
+	script := writeBundle(t, dir, "fake-runtime", "#!/bin/sh\necho x >> "+counter+"\necho v20.1.0\n")
+	os.Chmod(script, 0755)
+	fake := jsRuntime{name: "fake", command: script, minVersion: 16}
+
+	for i := 0; i < 3; i++ {
+		if err := checkRuntime(&fake); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if runs, _ := os.ReadFile(counter); string(runs) != "x\n" {
+		t.Fatalf("expected the version to be checked once, got %q", runs)
+	}
+}
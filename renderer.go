@@ -0,0 +1,121 @@
+package melange
+
+import (
+	_ "embed"
+	"fmt"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// renderer evaluates static bundles, rendering each of their elements to
+// HTML. Renderers must be safe to use from multiple goroutines.
+type renderer interface {
+	render(bundle string) (*renderResponse, error)
+	Close() error
+}
+
+// jsRuntime describes a JavaScript runtime that can render static bundles.
+type jsRuntime struct {
+	name string
+	// command is the runtime's executable. Runtimes without one are
+	// embedded in melange.
+	command string
+	// hostJs is the script that the runtime runs to serve render requests.
+	hostJs []byte
+	// args returns the arguments that start the host script.
+	args func(hostFile, sockAddr string) []string
+	// format is the module format of the static bundles.
+	format api.Format
+	// external is set if the runtime can require framework packages from the
+	// site's node_modules, instead of them being bundled.
+	external bool
+	// cachesModules is set if the runtime can't evict modules that it has
+	// imported, so its hosts are restarted to import a bundle again.
+	cachesModules bool
+	// install is a hint for installing the runtime.
+	install string
+	// minVersion is the oldest supported major version of the runtime.
+	minVersion int
+}
+
+//go:embed nodejs_host.js
+var nodeHostJs []byte
+
+//go:embed bun_host.js
+var bunHostJs []byte
+
+//go:embed deno_host.js
+var denoHostJs []byte
+
+var nodeRuntime = jsRuntime{
+	name:       "node",
+	command:    "node",
+	hostJs:     nodeHostJs,
+	format:     api.FormatCommonJS,
+	external:   true,
+	install:    "https://nodejs.org",
+	minVersion: 16,
+	args: func(hostFile, sockAddr string) []string {
+		return []string{hostFile, sockAddr}
+	},
+}
+
+var bunRuntime = jsRuntime{
+	name:       "bun",
+	command:    "bun",
+	hostJs:     bunHostJs,
+	format:     api.FormatCommonJS,
+	external:   true,
+	install:    "https://bun.sh",
+	minVersion: 1,
+	args: func(hostFile, sockAddr string) []string {
+		return []string{"run", hostFile, sockAddr}
+	},
+}
+
+// Deno can only resolve packages from node_modules with extra
+// configuration, so its bundles are self-contained ES modules.
+var denoRuntime = jsRuntime{
+	name:          "deno",
+	command:       "deno",
+	hostJs:        denoHostJs,
+	format:        api.FormatESModule,
+	cachesModules: true,
+	install:       "https://deno.land",
+	minVersion:    1,
+	args: func(hostFile, sockAddr string) []string {
+		return []string{"run", "--allow-read", "--allow-write", "--allow-env", hostFile, sockAddr}
+	},
+}
+
+var gojaRuntime = jsRuntime{
+	name:   "goja",
+	format: api.FormatCommonJS,
+}
+
+var jsRuntimes = map[string]*jsRuntime{
+	nodeRuntime.name: &nodeRuntime,
+	bunRuntime.name:  &bunRuntime,
+	denoRuntime.name: &denoRuntime,
+	gojaRuntime.name: &gojaRuntime,
+}
+
+func getRuntime(name string) (*jsRuntime, error) {
+	if runtime, ok := jsRuntimes[name]; ok {
+		return runtime, nil
+	}
+
+	return nil, fmt.Errorf("unknown runtime %q", name)
+}
+
+// newRenderer creates a renderer for a runtime. External runtimes render
+// with a pool of worker processes, whereas goja runs in process. Processes
+// are only started once something is rendered, and preflight checks that
+// the runtime is installed before then.
+func newRenderer(runtime *jsRuntime, workers int) renderer {
+	if runtime.command == "" {
+		return newGojaRenderer()
+	}
+
+	return newHostPool(runtime, workers)
+}
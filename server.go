@@ -0,0 +1,248 @@
+package melange
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"sync"
+	"syscall"
+)
+
+//go:embed dev_client.js
+var devClientJs []byte
+
+const devClientScript = `<script type="module" src="/_melange/client.js"></script>` + "\n"
+
+type serverEvent struct {
+	name string
+	data string
+}
+
+//go:embed error_overlay.gohtml
+var errorOverlayHtml string
+
+var errorOverlayTemplate = template.Must(template.New("overlay").Parse(errorOverlayHtml))
+
+type devServer struct {
+	options BuildOptions
+	site    *Site
+	files   http.Handler
+	watcher *watcher
+	mu      sync.Mutex
+	err     error
+	clients map[chan serverEvent]bool
+}
+
+// Serve builds the site and serves it from addr, which defaults to the port
+// in the site's config. The site is rebuilt whenever its files change, and
+// open pages are told to reload. While the build is failing, pages are
+// replaced with an overlay describing the errors, including errors in the
+// site's config. Changes to the output and pages directories in the config
+// take effect on the next build, but the server has to be restarted to
+// listen on a different port.
+func Serve(options BuildOptions, addr string) error {
+	options.liveReload = true
+	options.incremental = &incrementalBuild{}
+	hostRuntime, workers, err := siteRuntime(options)
+
+	if err != nil {
+		return err
+	}
+
+	site, err := createSite(options)
+
+	// The first build reports the error, and the site is served from the
+	// default directories until it's fixed
+	if err != nil {
+		inputDir, err := siteDir(options)
+
+		if err != nil {
+			return err
+		}
+
+		config := defaultConfig()
+
+		site = Site{
+			inputDir:  inputDir,
+			outputDir: resolvePath(inputDir, config.OutputDir),
+			pagesDir:  resolvePath(inputDir, config.PagesDir),
+			config:    config,
+		}
+	}
+
+	if addr == "" {
+		addr = fmt.Sprintf(":%d", site.config.Port)
+	}
+
+	options.host = newRenderer(hostRuntime, workers)
+	defer options.host.Close()
+
+	server := &devServer{
+		options: options,
+		clients: map[chan serverEvent]bool{},
+	}
+
+	server.useSite(&site)
+	server.rebuild(nil)
+	server.watcher = newWatcher(watchedDirs(server.site), ignoreSiteFile(server.site))
+	go server.watcher.watch(nil, server.rebuild)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_melange/events", server.events)
+	mux.HandleFunc("/_melange/client.js", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/javascript")
+		w.Write(devClientJs)
+	})
+	mux.HandleFunc("/", server.serveSite)
+
+	httpServer := &http.Server{Addr: addr, Handler: mux}
+
+	// Shut down cleanly on interrupt, so that the node host is stopped too
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		httpServer.Close()
+	}()
+
+	fmt.Printf("serving site at http://localhost%s...\n", addr)
+	err = httpServer.ListenAndServe()
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+func (server *devServer) rebuild(changed []string) {
+	result, err := Build(server.options)
+
+	server.mu.Lock()
+	recovered := server.err != nil && err == nil
+	server.err = err
+	server.mu.Unlock()
+
+	if err != nil {
+		log.Println(err)
+		server.broadcast(serverEvent{name: "reload"})
+		return
+	}
+
+	fmt.Printf("built site in %s\n", result.Duration)
+	moved := server.useSite(result.Site)
+
+	// Pages that are showing the error overlay need to reload to recover
+	if updates, ok := islandUpdates(result.Site, changed); ok && changed != nil && !recovered && !moved {
+		data, _ := json.Marshal(updates)
+		server.broadcast(serverEvent{name: "update", data: string(data)})
+	} else {
+		server.broadcast(serverEvent{name: "reload"})
+	}
+}
+
+// useSite serves files from the site's output directory, and watches the
+// directories that it's read from. These can change when the site's config
+// does. It reports whether they changed.
+func (server *devServer) useSite(site *Site) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	prev := server.site
+	server.site = site
+
+	if prev != nil && prev.outputDir == site.outputDir && prev.pagesDir == site.pagesDir {
+		return false
+	}
+
+	server.files = http.FileServer(http.Dir(site.outputDir))
+
+	// The watcher calls rebuild from its own goroutine, so it's safe to reset
+	// here, and the first build happens before it's created.
+	if server.watcher != nil {
+		server.watcher.reset(watchedDirs(site), ignoreSiteFile(site))
+	}
+
+	return prev != nil
+}
+
+func (server *devServer) serveSite(w http.ResponseWriter, r *http.Request) {
+	server.mu.Lock()
+	err := server.err
+	files := server.files
+	server.mu.Unlock()
+
+	ext := path.Ext(r.URL.Path)
+	isPage := ext == "" || ext == ".html"
+
+	if err != nil && isPage {
+		errs := AsBuildErrors(err)
+
+		if errs == nil {
+			errs = BuildErrors{{Category: "build", Message: err.Error()}}
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		errorOverlayTemplate.Execute(w, errs)
+		return
+	}
+
+	files.ServeHTTP(w, r)
+}
+
+func (server *devServer) broadcast(event serverEvent) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	for client := range server.clients {
+		select {
+		case client <- event:
+		default:
+			// Drop events for clients that aren't keeping up
+		}
+	}
+}
+
+// events streams server events to the browser with Server-Sent Events.
+func (server *devServer) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+
+	client := make(chan serverEvent, 8)
+	server.mu.Lock()
+	server.clients[client] = true
+	server.mu.Unlock()
+
+	defer func() {
+		server.mu.Lock()
+		delete(server.clients, client)
+		server.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-client:
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.name, event.data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package melange
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// sourceMap is a decoded source map. Only the parts needed to map
+// generated positions back to their sources are kept.
+type sourceMap struct {
+	dir     string
+	sources []string
+	// lines holds the mappings for each generated line, sorted by column.
+	lines [][]mapping
+}
+
+type mapping struct {
+	genColumn int
+	source    int
+	line      int
+	column    int
+}
+
+func readSourceMap(file string) (*sourceMap, error) {
+	data, err := os.ReadFile(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var raw struct {
+		Sources  []string `json:"sources"`
+		Mappings string   `json:"mappings"`
+	}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	lines, err := decodeMappings(raw.Mappings)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &sourceMap{dir: path.Dir(file), sources: raw.Sources, lines: lines}, nil
+}
+
+const base64Chars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// decodeMappings decodes the base64 VLQ mappings of a source map.
+func decodeMappings(mappings string) ([][]mapping, error) {
+	var lines [][]mapping
+	var source, line, column int
+
+	for _, group := range strings.Split(mappings, ";") {
+		var segments []mapping
+		genColumn := 0
+
+		for _, segment := range strings.Split(group, ",") {
+			if segment == "" {
+				continue
+			}
+
+			fields, err := decodeVLQ(segment)
+
+			if err != nil {
+				return nil, err
+			}
+
+			genColumn += fields[0]
+
+			// Segments with a single field don't map to a source
+			if len(fields) < 4 {
+				continue
+			}
+
+			source += fields[1]
+			line += fields[2]
+			column += fields[3]
+			segments = append(segments, mapping{genColumn, source, line, column})
+		}
+
+		lines = append(lines, segments)
+	}
+
+	return lines, nil
+}
+
+func decodeVLQ(segment string) ([]int, error) {
+	var fields []int
+	value, shift := 0, 0
+
+	for _, char := range segment {
+		digit := strings.IndexRune(base64Chars, char)
+
+		if digit < 0 {
+			return nil, errors.New("invalid source map mapping")
+		}
+
+		value += (digit & 31) << shift
+
+		if digit&32 != 0 {
+			shift += 5
+			continue
+		}
+
+		if value&1 != 0 {
+			fields = append(fields, -(value >> 1))
+		} else {
+			fields = append(fields, value>>1)
+		}
+
+		value, shift = 0, 0
+	}
+
+	return fields, nil
+}
+
+// lookup maps a 1-based generated line and column back to a source file
+// and a 1-based line and column within it.
+func (sm *sourceMap) lookup(line int, column int) (string, int, int, bool) {
+	if line < 1 || line > len(sm.lines) {
+		return "", 0, 0, false
+	}
+
+	segments := sm.lines[line-1]
+	i := sort.Search(len(segments), func(i int) bool {
+		return segments[i].genColumn > column-1
+	})
+
+	if i == 0 {
+		return "", 0, 0, false
+	}
+
+	m := segments[i-1]
+
+	if m.source >= len(sm.sources) {
+		return "", 0, 0, false
+	}
+
+	source := sm.sources[m.source]
+
+	// Sources from virtual modules are namespaced rather than relative paths
+	if !strings.Contains(source, ":") {
+		source = path.Join(sm.dir, source)
+	}
+
+	return source, m.line + 1, m.column + 1, true
+}
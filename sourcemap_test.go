@@ -0,0 +1,50 @@
+package melange
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeVLQ(t *testing.T) {
+	fields, err := decodeVLQ("AAgBC")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(fields, []int{0, 0, 16, 1}) {
+		t.Fatalf("unexpected fields %v", fields)
+	}
+
+	if fields, _ := decodeVLQ("D"); fields[0] != -1 {
+		t.Fatalf("expected negative values to be decoded, got %v", fields)
+	}
+}
+
+func TestSourceMapLookup(t *testing.T) {
+	// Line 1 maps columns 0 and 4 to 1:1 and 1:5 of the first source, line
+	// 2 maps column 2 to 3:1 of the second source.
+	lines, err := decodeMappings("AAAA,IAAI;ECEJ")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm := &sourceMap{dir: "/cache", sources: []string{"../pages/a.tsx", "framework:preact"}, lines: lines}
+
+	source, line, column, ok := sm.lookup(1, 7)
+
+	if !ok || source != "/pages/a.tsx" || line != 1 || column != 5 {
+		t.Fatalf("unexpected lookup %s:%d:%d", source, line, column)
+	}
+
+	source, line, column, ok = sm.lookup(2, 3)
+
+	if !ok || source != "framework:preact" || line != 3 || column != 1 {
+		t.Fatalf("unexpected lookup %s:%d:%d", source, line, column)
+	}
+
+	if _, _, _, ok := sm.lookup(2, 1); ok {
+		t.Fatal("expected columns before the first mapping to be unmapped")
+	}
+}
@@ -0,0 +1,189 @@
+package melange
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//go:embed taxonomy.gohtml
+var defaultTaxonomyHtml string
+
+// Taxonomy groups pages by the values of a front matter key, such as
+// "tags: [go, esbuild]".
+type Taxonomy struct {
+	Name  string
+	Url   string
+	Terms []*Term
+}
+
+// Term is one of the values in a taxonomy, with the pages that have it.
+type Term struct {
+	Name  string
+	Slug  string
+	Url   string
+	Pages []*Page
+}
+
+// Count returns the number of pages that have the term.
+func (term *Term) Count() int {
+	return len(term.Pages)
+}
+
+// taxonomyIndex holds a site's taxonomies. It exists before any templates
+// are parsed, so that their funcs can refer to it, and it's filled in once
+// every page's front matter has been read.
+type taxonomyIndex struct {
+	names      []string
+	taxonomies map[string]*Taxonomy
+}
+
+// terms returns the terms in a taxonomy, sorted by name.
+func (index *taxonomyIndex) terms(name string) ([]*Term, error) {
+	if taxonomy, ok := index.taxonomies[name]; ok {
+		return taxonomy.Terms, nil
+	}
+
+	return nil, fmt.Errorf("unknown taxonomy %q, expected one of %s", name, strings.Join(index.names, ", "))
+}
+
+// loadTaxonomies collects the terms of each taxonomy from the pages' front
+// matter, then adds an overview page for each taxonomy, and a page for each
+// of its terms, which are rendered with "_taxonomy.html".
+func loadTaxonomies(site *Site) {
+	index := site.taxonomies
+	index.taxonomies = map[string]*Taxonomy{}
+	pages := site.Pages()
+
+	for _, name := range index.names {
+		taxonomy := &Taxonomy{Name: name, Url: "/" + name + "/"}
+		terms := map[string]*Term{}
+
+		for _, page := range pages {
+			for _, value := range termNames(page.Data[name]) {
+				slug := termSlug(value)
+				term := terms[slug]
+
+				if slug == "" {
+					continue
+				} else if term == nil {
+					term = &Term{Name: value, Slug: slug, Url: taxonomy.Url + slug + "/"}
+					terms[slug] = term
+					taxonomy.Terms = append(taxonomy.Terms, term)
+				}
+
+				// Pages can list the same term more than once
+				if n := len(term.Pages); n == 0 || term.Pages[n-1] != page {
+					term.Pages = append(term.Pages, page)
+				}
+			}
+		}
+
+		sort.Slice(taxonomy.Terms, func(i, j int) bool {
+			return taxonomy.Terms[i].Slug < taxonomy.Terms[j].Slug
+		})
+
+		index.taxonomies[name] = taxonomy
+	}
+
+	// Pages are added after every taxonomy is complete, so that generated
+	// pages are never part of a taxonomy themselves.
+	for _, name := range index.names {
+		taxonomy := index.taxonomies[name]
+
+		if len(taxonomy.Terms) == 0 {
+			continue
+		}
+
+		addTaxonomyPage(site, taxonomy, nil)
+
+		for _, term := range taxonomy.Terms {
+			addTaxonomyPage(site, taxonomy, term)
+		}
+	}
+}
+
+// addTaxonomyPage adds the page for a taxonomy's overview, or for one of its
+// terms, unless the site already has a page at the same path.
+func addTaxonomyPage(site *Site, taxonomy *Taxonomy, term *Term) {
+	dir := "/" + taxonomy.Name
+	title := taxonomy.Name
+
+	if term != nil {
+		dir = path.Join(dir, term.Slug)
+		title = term.Name
+	}
+
+	relPath := path.Join(dir, "index.html")
+
+	for _, page := range site.pages {
+		if pageUrl(page.relPath) == pageUrl(relPath) {
+			return
+		}
+	}
+
+	id := shortHash(relPath)
+	site.directories = append(site.directories, dir)
+
+	site.pages[id] = &Page{
+		id:        id,
+		outputDir: site.outputDir,
+		dir:       path.Join(site.pagesDir, dir),
+		depth:     strings.Count(dir, "/"),
+		absPath:   site.taxonomyPath,
+		relPath:   relPath,
+		Name:      "index.html",
+		Url:       pageUrl(relPath),
+		Title:     title,
+		Data:      map[string]any{"title": title},
+		taxonomy:  taxonomy,
+		term:      term,
+	}
+}
+
+// renderTaxonomyPage renders a generated page's contents with the taxonomy
+// template.
+func renderTaxonomyPage(page *Page, site *Site, scope renderContext) error {
+	var buf bytes.Buffer
+	scope.Taxonomy = page.taxonomy
+	scope.Term = page.term
+
+	if err := site.taxonomyTemplate.Execute(&buf, scope); err != nil {
+		return newPartialAwareError(site, TemplateExecError, site.taxonomyPath, err)
+	}
+
+	page.Contents = buf.String()
+	return nil
+}
+
+// termNames returns the terms that a page lists in its front matter, which
+// can be a list or a single value.
+func termNames(value any) []string {
+	var names []string
+
+	switch value := value.(type) {
+	case nil:
+	case []any:
+		for _, v := range value {
+			if v != nil {
+				names = append(names, strings.TrimSpace(fmt.Sprint(v)))
+			}
+		}
+	default:
+		names = append(names, strings.TrimSpace(fmt.Sprint(value)))
+	}
+
+	return names
+}
+
+var termSlugRegex = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+// termSlug is the name of a term in urls. Terms that only differ in case or
+// punctuation share a slug, and are treated as the same term.
+func termSlug(name string) string {
+	return strings.Trim(termSlugRegex.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
@@ -0,0 +1,116 @@
+package melange
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestTaxonomies(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"melange.yaml":         "taxonomies: [tags, series]\n",
+		"pages/_theme.html":    `{{ range taxonomy "tags" }}[{{ .Name }}]{{ end }}{{ .Page.Contents | safeHTML }}`,
+		"pages/_taxonomy.html": `{{ if .Term }}{{ .Taxonomy.Name }}/{{ .Term.Name }}:{{ range .Term.Pages }} {{ .Title }}{{ end }}{{ else }}{{ range .Taxonomy.Terms }}{{ .Url }}={{ .Count }} {{ end }}{{ end }}`,
+		"pages/index.md":       `{{ range taxonomy "series" }}{{ .Name }}{{ end }}`,
+		"pages/posts/a.md":     "---\ntitle: A\ntags: [Go, esbuild]\nseries: Basics\n---\n",
+		"pages/posts/b.md":     "---\ntitle: B\ntags: [go, go]\n---\n",
+	})
+
+	if _, err := Build(BuildOptions{Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"_site/index.html":               "[esbuild][Go]<p>Basics</p>\n",
+		"_site/tags/index.html":          "[esbuild][Go]/tags/esbuild/=1 /tags/go/=2 ",
+		"_site/tags/go/index.html":       "[esbuild][Go]tags/Go: A B",
+		"_site/series/basics/index.html": "[esbuild][Go]series/Basics: A",
+	}
+
+	for file, contents := range expected {
+		html, err := os.ReadFile(path.Join(dir, file))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(html) != contents {
+			t.Fatalf("expected %s to be %q, got %q", file, contents, html)
+		}
+	}
+}
+
+func TestTaxonomyPagesAreNotListed(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"pages/index.md":      `{{ range pages }}{{ .Url }} {{ end }}`,
+		"pages/tags/index.md": "custom",
+		"pages/post.md":       "---\ntags: [go]\n---\n",
+	})
+
+	if _, err := Build(BuildOptions{Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	index, _ := os.ReadFile(path.Join(dir, "_site/index.html"))
+	tags, _ := os.ReadFile(path.Join(dir, "_site/tags/index.html"))
+
+	if strings.Contains(string(index), "/tags/go/") {
+		t.Fatalf("expected generated pages not to be listed, got %q", index)
+	}
+
+	if !strings.Contains(string(tags), "custom") {
+		t.Fatalf("expected pages to take precedence over generated pages, got %q", tags)
+	}
+}
+
+func TestUnknownTaxonomy(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"pages/index.md": `{{ taxonomy "categories" }}`,
+	})
+
+	_, err := Build(BuildOptions{Dir: dir})
+
+	if err == nil || !strings.Contains(err.Error(), `unknown taxonomy "categories", expected one of tags`) {
+		t.Fatalf("expected unknown taxonomy error, got %v", err)
+	}
+}
+
+func TestTermSlug(t *testing.T) {
+	tests := map[string]string{
+		"Go":            "go",
+		"Static Sites!": "static-sites",
+		"C++ / C#":      "c-c",
+		"Ünïcödé":       "ünïcödé",
+	}
+
+	for name, expected := range tests {
+		if slug := termSlug(name); slug != expected {
+			t.Fatalf("expected slug for %q to be %q, got %q", name, expected, slug)
+		}
+	}
+}
+
+func TestRemovedTermsAreRemoved(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"pages/post.md": "---\ntags: [go]\n---\n",
+	})
+
+	if _, err := Build(BuildOptions{Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	os.WriteFile(path.Join(dir, "pages/post.md"), []byte("---\ntags: [rust]\n---\n"), os.ModePerm)
+
+	if _, err := Build(BuildOptions{Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path.Join(dir, "_site/tags/go/index.html")); !os.IsNotExist(err) {
+		t.Fatal("expected the page for the removed term to be removed")
+	}
+
+	if _, err := os.Stat(path.Join(dir, "_site/tags/rust/index.html")); err != nil {
+		t.Fatal(err)
+	}
+}
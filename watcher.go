@@ -0,0 +1,126 @@
+package melange
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// watcher polls directory trees for changes. Polling avoids platform
+// specific file system notifications and is fast enough for the size of a
+// typical site.
+type watcher struct {
+	roots    []string
+	ignore   func(path string) bool
+	interval time.Duration
+	files    map[string]time.Time
+}
+
+func newWatcher(roots []string, ignore func(path string) bool) *watcher {
+	w := &watcher{interval: 250 * time.Millisecond}
+	w.reset(roots, ignore)
+	return w
+}
+
+// reset changes the directories that are watched, and forgets any changes
+// since the last scan.
+func (w *watcher) reset(roots []string, ignore func(path string) bool) {
+	w.roots = roots
+	w.ignore = ignore
+	w.files = w.scan()
+}
+
+func (w *watcher) scan() map[string]time.Time {
+	files := map[string]time.Time{}
+
+	for _, root := range w.roots {
+		filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+
+			if path != root && w.ignore(path) {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+
+			if !entry.IsDir() {
+				if info, err := entry.Info(); err == nil {
+					files[path] = info.ModTime()
+				}
+			}
+
+			return nil
+		})
+	}
+
+	return files
+}
+
+// changes rescans the tree and returns the paths that were added, modified
+// or removed since the last scan.
+func (w *watcher) changes() []string {
+	var changed []string
+	files := w.scan()
+
+	for path, modTime := range files {
+		if prev, ok := w.files[path]; !ok || !prev.Equal(modTime) {
+			changed = append(changed, path)
+		}
+	}
+
+	for path := range w.files {
+		if _, ok := files[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+
+	w.files = files
+	return changed
+}
+
+// watch calls onChange with the changed paths whenever the tree changes,
+// until done is closed.
+func (w *watcher) watch(done <-chan struct{}, onChange func(changed []string)) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if changed := w.changes(); len(changed) > 0 {
+				onChange(changed)
+			}
+		}
+	}
+}
+
+// watchedDirs returns the directories that a site is read from. The pages
+// directory is usually inside the site's directory, but it can be anywhere.
+func watchedDirs(site *Site) []string {
+	dirs := []string{site.inputDir}
+
+	if site.pagesDir != site.inputDir && !strings.HasPrefix(site.pagesDir, site.inputDir+"/") {
+		dirs = append(dirs, site.pagesDir)
+	}
+
+	return dirs
+}
+
+// ignoreSiteFile reports whether changes to a file should not trigger a
+// rebuild of the site.
+func ignoreSiteFile(site *Site) func(path string) bool {
+	return func(path string) bool {
+		name := filepath.Base(path)
+		return strings.HasPrefix(name, ".") ||
+			name == "node_modules" ||
+			path == site.outputDir ||
+			strings.HasPrefix(path, site.outputDir+"/")
+	}
+}
@@ -0,0 +1,79 @@
+package melange
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatcherChanges(t *testing.T) {
+	dir := createTestSite(t, map[string]string{
+		"pages/index.md":          "Hello",
+		"node_modules/pkg/a.js":   "",
+		"pages/.hidden/ignore.md": "",
+	})
+
+	w := newWatcher([]string{dir}, func(p string) bool {
+		return strings.HasPrefix(path.Base(p), ".") || path.Base(p) == "node_modules"
+	})
+
+	if changed := w.changes(); len(changed) != 0 {
+		t.Fatalf("expected no changes, got %v", changed)
+	}
+
+	index := path.Join(dir, "pages/index.md")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(index, later, later)
+	os.WriteFile(path.Join(dir, "pages/new.md"), nil, os.ModePerm)
+	os.WriteFile(path.Join(dir, "node_modules/pkg/b.js"), nil, os.ModePerm)
+
+	changed := w.changes()
+
+	if len(changed) != 2 {
+		t.Fatalf("expected 2 changes, got %v", changed)
+	}
+
+	os.Remove(index)
+
+	if changed := w.changes(); len(changed) != 1 || changed[0] != index {
+		t.Fatalf("expected removed files to be reported, got %v", changed)
+	}
+}
+
+func TestWatcherReset(t *testing.T) {
+	site := createTestSite(t, map[string]string{"melange.yaml": ""})
+	content := createTestSite(t, map[string]string{"index.md": ""})
+	w := newWatcher([]string{site}, func(p string) bool { return false })
+
+	w.reset([]string{site, content}, func(p string) bool { return false })
+
+	if changed := w.changes(); len(changed) != 0 {
+		t.Fatalf("expected reset to forget the new directory's files, got %v", changed)
+	}
+
+	post := path.Join(content, "post.md")
+	os.WriteFile(post, nil, os.ModePerm)
+
+	if changed := w.changes(); len(changed) != 1 || changed[0] != post {
+		t.Fatalf("expected changes in every directory to be reported, got %v", changed)
+	}
+}
+
+func TestWatchedDirs(t *testing.T) {
+	tests := map[string][]string{
+		"/site/pages":   {"/site"},
+		"/site":         {"/site"},
+		"/content":      {"/site", "/content"},
+		"/site-content": {"/site", "/site-content"},
+	}
+
+	for pagesDir, expected := range tests {
+		dirs := watchedDirs(&Site{inputDir: "/site", pagesDir: pagesDir})
+
+		if strings.Join(dirs, ",") != strings.Join(expected, ",") {
+			t.Fatalf("expected %s to watch %v, got %v", pagesDir, expected, dirs)
+		}
+	}
+}